@@ -8,76 +8,576 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
 	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+	"github.com/offchainlabs/nitro/util/containers"
 	"github.com/offchainlabs/nitro/validator"
 )
 
+// blockStateTooFarHash is the step hash of every position at or past the too far boundary.
+var blockStateTooFarHash common.Hash
+
+func init() {
+	blockStateTooFarHash = crypto.Keccak256Hash([]byte(DefaultBlockStateHashPrefixes.TooFar))
+}
+
+// batchSearchIterationsHistogram records how many batches each batch binary search read,
+// showing how densely packed batches are and how much a batch metadata cache would help.
+var batchSearchIterationsHistogram = metrics.NewRegisteredHistogram("arb/validator/challenge/block/batchsearch/iterations", nil, metrics.NewBoundedHistogramSample())
+
+// The durations of block result lookups in nanoseconds, by where they were looked up, to tell whether block retrieval
+// or batch metadata lookups are slowing a challenge down. Results served from the result cache aren't timed.
+var (
+	streamerResultDurationHistogram = metrics.NewRegisteredHistogram("arb/validator/challenge/block/result/streamer/duration", nil, metrics.NewBoundedHistogramSample())
+	fallbackResultDurationHistogram = metrics.NewRegisteredHistogram("arb/validator/challenge/block/result/fallback/duration", nil, metrics.NewBoundedHistogramSample())
+)
+
+// ErrBatchMessageCountsNotMonotonic is returned by strict batch searches when the inbox tracker
+// reports a batch message count that's lower than that of an earlier batch.
+var ErrBatchMessageCountsNotMonotonic = errors.New("batch message counts are not monotonic")
+
+// ErrChallengeGlobalStatesChanged is returned by VerifyAgainstContract if the challenge's global states
+// on L1 no longer match the ones the backend was created with, e.g. after an L1 reorg.
+var ErrChallengeGlobalStatesChanged = errors.New("challenge global states changed on L1")
+
+// ErrChallengeNotInitialized is returned when creating a backend from an InitiatedChallenge event whose start
+// global state is all zero, as is read before a challenge has been initialized. Callers polling for a challenge
+// should retry later rather than treat it as a fatal setup error.
+var ErrChallengeNotInitialized = errors.New("challenge not yet initialized")
+
+// ErrBatchOutsideChallenge is returned by PositionsForBatch if none of a batch's positions are in the challenge.
+var ErrBatchOutsideChallenge = errors.New("batch is outside the challenge")
+
+// ErrBatchSearchDidNotConverge is returned if a batch binary search exceeds its iteration cap.
+var ErrBatchSearchDidNotConverge = errors.New("batch search did not converge")
+
+// BlockChallengeBackendConfig holds the optional behaviors of a BlockChallengeBackend. Every field's zero value
+// is its default, so the zero config behaves the same as DefaultBlockChallengeBackendConfig, and constructors
+// given a nil config use the default.
+type BlockChallengeBackendConfig struct {
+	// StrictBatchOrdering verifies while binary searching batches that their message counts are
+	// non-decreasing, turning corrupt inbox tracker data into an error rather than a wrong batch. Off by default.
+	StrictBatchOrdering bool
+	// WaitForBatches makes construction poll the inbox tracker until the challenge's start and end batches
+	// are available, rather than failing if they haven't been ingested yet, e.g. while the node is syncing.
+	// Off by default.
+	WaitForBatches bool
+	// SetupRetries is how many times construction retries a failed read of the challenge's start or end batch,
+	// doubling the wait between attempts from SetupRetryBackoff, which is a second if zero. It's ignored if
+	// WaitForBatches is set. Zero by default, so a failed read fails construction.
+	SetupRetries      int
+	SetupRetryBackoff time.Duration
+	// VerifySuppliedStates makes SetRangeWithStates check the global states it's given against ones
+	// derived from the inbox tracker and streamer, at the cost of the lookups it'd otherwise skip. Off by default.
+	VerifySuppliedStates bool
+	// ResultCacheSize is how many block results to cache by message count, as bisection revisits the same
+	// positions. Only the block hash and send root are kept, not the block. Zero, the default, disables the cache.
+	ResultCacheSize int
+	// VerifyStartBlock makes construction check that the start global state's block hash is the hash of the
+	// streamer's block at the challenge's start message count, catching a start state that doesn't chain off
+	// the previous batch's messages as the node assembled them. Block results don't include the parent hash,
+	// so it's the block itself that's checked. Off by default, as it costs a block lookup.
+	VerifyStartBlock bool
+	// HashPrefixes are the prefixes hashed with block states to compute step hashes, for chains whose challenge
+	// contract uses different ones. Each empty prefix defaults to DefaultBlockStateHashPrefixes' prefix.
+	HashPrefixes BlockStateHashPrefixes
+	// VerifyBeforeSubmit makes issuing an execution challenge first check it'd pass the challenge contract's checks,
+	// rather than wasting gas on a transaction that reverts. It costs an extra RPC round trip to read the challenge's
+	// state hash from the contract. Off by default.
+	VerifyBeforeSubmit bool
+	// Hasher computes the keccak256 hashes of step hashes and the global states in them, e.g. so a hardware
+	// accelerated implementation can be used. It must produce the same hashes as crypto.Keccak256Hash, which is
+	// used if it's nil, the default.
+	Hasher validator.Keccak256Hasher
+	// FallbackResultSource, if set, is consulted for block results the streamer fails to return. It's for nodes
+	// that prune old blocks but have access to archival data, e.g. an archive peer, so a challenge doesn't stall.
+	FallbackResultSource BlockResultSource
+	// FallbackBatchSource, if set, is consulted for batch message counts the inbox tracker fails to return, so a
+	// validator whose inbox tracker is slightly behind can still respond to a challenge. It's expected to read batches
+	// from L1, which costs L1 RPCs and parsing the batch on every call, so it should only be used to catch up.
+	FallbackBatchSource BatchMessageCountSource
+	// Clock is used for the backend's time-dependent logic, such as waiting for batches. If nil, the real clock is used.
+	Clock Clock
+	// Logger receives debug logs of the backend's decisions. If nil, the root logger is used.
+	Logger log.Logger
+}
+
+var DefaultBlockChallengeBackendConfig = BlockChallengeBackendConfig{
+	StrictBatchOrdering:  false,
+	WaitForBatches:       false,
+	SetupRetries:         0,
+	SetupRetryBackoff:    defaultSetupRetryBackoff,
+	VerifySuppliedStates: false,
+	ResultCacheSize:      0,
+	VerifyStartBlock:     false,
+	HashPrefixes:         BlockStateHashPrefixes{},
+	VerifyBeforeSubmit:   false,
+	Hasher:               nil,
+	FallbackResultSource: nil,
+	FallbackBatchSource:  nil,
+	Clock:                nil,
+	Logger:               nil,
+}
+
+const defaultSetupRetryBackoff = time.Second
+
+// BlockResultSource provides block results by message count, as TransactionStreamerInterface does.
+type BlockResultSource interface {
+	ResultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error)
+}
+
+// Clock is an interface for getting the current time and waiting for time to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// BatchMessageCountSource provides the message counts of batches, as InboxTrackerInterface does.
+type BatchMessageCountSource interface {
+	GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error)
+}
+
+// fallbackInboxTracker is an inbox tracker that reads through to a fallback for batches it fails to return.
+type fallbackInboxTracker struct {
+	InboxTrackerInterface
+	fallback BatchMessageCountSource
+}
+
+func (t *fallbackInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	msgCount, err := t.InboxTrackerInterface.GetBatchMessageCount(seqNum)
+	if err == nil {
+		return msgCount, nil
+	}
+	msgCount, fallbackErr := t.fallback.GetBatchMessageCount(seqNum)
+	if fallbackErr != nil {
+		return 0, fmt.Errorf("failed to get batch %v message count from inbox tracker (%w) or fallback source (%w)", seqNum, err, fallbackErr)
+	}
+	return msgCount, nil
+}
+
+const batchWaitInitialBackoff = 100 * time.Millisecond
+const batchWaitMaxBackoff = 5 * time.Second
+
 type BlockChallengeBackend struct {
-	streamer               TransactionStreamerInterface
-	startMsgCount          arbutil.MessageIndex
-	startPosition          uint64
-	endPosition            uint64
-	startGs                validator.GoGlobalState
-	endGs                  validator.GoGlobalState
-	inboxTracker           InboxTrackerInterface
+	config        *BlockChallengeBackendConfig
+	logger        log.Logger
+	streamer      TransactionStreamerInterface
+	startMsgCount arbutil.MessageIndex
+	// The message count after all the batches the challenged assertion read, at position tooFarStartsAtPosition-1
+	endMsgCount   arbutil.MessageIndex
+	startPosition uint64
+	endPosition   uint64
+	startGs       validator.GoGlobalState
+	endGs         validator.GoGlobalState
+	// The global states the challenge was created with, which unlike startGs and endGs aren't updated by SetRange
+	initialStartGs validator.GoGlobalState
+	initialEndGs   validator.GoGlobalState
+	inboxTracker   InboxTrackerInterface
+	// tooFarStartsAtPosition is the first position after the last message of the batches the challenged
+	// assertion read. It's fixed for the lifetime of the challenge: SetRange only narrows which positions
+	// are being disputed, and doesn't change how many batches the assertion read, so it never moves.
 	tooFarStartsAtPosition uint64
+
+	// Counters of the statuses returned by GetInfoAtStep, nil unless EnableStepStatusMetrics was called,
+	// registered under stepStatusMetricsPrefix
+	finishedStepsCounter    metrics.Counter
+	tooFarStepsCounter      metrics.Counter
+	stepStatusMetricsPrefix string
+	// Block results by message count, which is never populated if config.ResultCacheSize is zero.
+	// It may be shared with other backends by a BlockChallengeBackendFactory.
+	resultCache *syncLruCache[arbutil.MessageIndex, execution.MessageResult]
+	// The cache of batch message counts the inbox tracker reads through, if it's from a BlockChallengeBackendFactory,
+	// so it can be cleared with the result cache when a reorg is detected.
+	batchCache *syncLruCache[uint64, arbutil.MessageIndex]
+}
+
+// syncLruCache is a containers.LruCache guarded by a mutex, so it can be used concurrently.
+type syncLruCache[K comparable, V any] struct {
+	mutex sync.Mutex
+	cache *containers.LruCache[K, V]
+}
+
+func newSyncLruCache[K comparable, V any](size int) *syncLruCache[K, V] {
+	return &syncLruCache[K, V]{cache: containers.NewLruCache[K, V](size)}
+}
+
+func (c *syncLruCache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cache.Get(key)
+}
+
+func (c *syncLruCache[K, V]) Add(key K, value V) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cache.Add(key, value)
+}
+
+func (c *syncLruCache[K, V]) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cache.Clear()
 }
 
 // Assert that BlockChallengeBackend implements ChallengeBackend
 var _ ChallengeBackend = (*BlockChallengeBackend)(nil)
 
 func NewBlockChallengeBackend(
+	ctx context.Context,
+	initialState *challengegen.ChallengeManagerInitiatedChallenge,
+	maxBatchesRead uint64,
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+) (*BlockChallengeBackend, error) {
+	return NewBlockChallengeBackendWithConfig(ctx, initialState, maxBatchesRead, streamer, inboxTracker, &DefaultBlockChallengeBackendConfig)
+}
+
+func NewBlockChallengeBackendWithConfig(
+	ctx context.Context,
 	initialState *challengegen.ChallengeManagerInitiatedChallenge,
 	maxBatchesRead uint64,
 	streamer TransactionStreamerInterface,
 	inboxTracker InboxTrackerInterface,
+	config *BlockChallengeBackendConfig,
 ) (*BlockChallengeBackend, error) {
 	startGs := validator.GoGlobalStateFromSolidity(initialState.StartState)
+	if startGs == (validator.GoGlobalState{}) {
+		return nil, fmt.Errorf("%w: start global state is zero", ErrChallengeNotInitialized)
+	}
+	return NewBlockChallengeBackendFromGlobalStates(
+		ctx,
+		startGs,
+		validator.GoGlobalStateFromSolidity(initialState.EndState),
+		maxBatchesRead,
+		streamer,
+		inboxTracker,
+		config,
+	)
+}
 
-	var startMsgCount arbutil.MessageIndex
-	if startGs.Batch > 0 {
-		var err error
-		startMsgCount, err = inboxTracker.GetBatchMessageCount(startGs.Batch - 1)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get challenge start batch metadata: %w", err)
-		}
+// NewBlockChallengeBackendFromGlobalStates creates a backend disputing the range between the given
+// global states, rather than reading them from an InitiatedChallenge event.
+// This allows simulating disputes without an on-chain challenge.
+// The global states aren't required to be at the start of a batch; a start state with a non-zero
+// PosInBatch is offset into its batch. Such a backend is useful for research, but unless the states
+// match a real assertion, the hashes it produces won't be accepted by the challenge contract.
+func NewBlockChallengeBackendFromGlobalStates(
+	ctx context.Context,
+	startGs validator.GoGlobalState,
+	endGs validator.GoGlobalState,
+	maxBatchesRead uint64,
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+	config *BlockChallengeBackendConfig,
+) (*BlockChallengeBackend, error) {
+	backend, _, err := NewBlockChallengeBackendWithDiagnostics(ctx, startGs, endGs, maxBatchesRead, streamer, inboxTracker, config)
+	return backend, err
+}
+
+// BlockChallengeDiagnostics records what was gathered while creating a block challenge backend,
+// showing how far setup got if it failed.
+type BlockChallengeDiagnostics struct {
+	StartGs        validator.GoGlobalState
+	EndGs          validator.GoGlobalState
+	MaxBatchesRead uint64
+	// StartMsgCount is only set if HaveStartMsgCount is, after the start batch was successfully read
+	StartMsgCount     arbutil.MessageIndex
+	HaveStartMsgCount bool
+	// EndMsgCount is only set if HaveEndMsgCount is, after the end batch was successfully read
+	EndMsgCount     arbutil.MessageIndex
+	HaveEndMsgCount bool
+}
+
+// NewBlockChallengeBackendWithDiagnostics is NewBlockChallengeBackendFromGlobalStates, but also returns
+// the diagnostics gathered during setup, which are returned even if it fails.
+func NewBlockChallengeBackendWithDiagnostics(
+	ctx context.Context,
+	startGs validator.GoGlobalState,
+	endGs validator.GoGlobalState,
+	maxBatchesRead uint64,
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+	config *BlockChallengeBackendConfig,
+) (*BlockChallengeBackend, *BlockChallengeDiagnostics, error) {
+	if config == nil {
+		config = &DefaultBlockChallengeBackendConfig
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Root()
+	}
+	diagnostics := &BlockChallengeDiagnostics{
+		StartGs:        startGs,
+		EndGs:          endGs,
+		MaxBatchesRead: maxBatchesRead,
+	}
+	// The end may be in the same batch as the start, as explained by SpansSingleBatch
+	if endGs.Batch < startGs.Batch {
+		return nil, diagnostics, fmt.Errorf("challenge end global state batch %v is before start global state batch %v", endGs.Batch, startGs.Batch)
+	}
+	if config.FallbackBatchSource != nil {
+		inboxTracker = &fallbackInboxTracker{InboxTrackerInterface: inboxTracker, fallback: config.FallbackBatchSource}
 	}
-	startMsgCount += arbutil.MessageIndex(startGs.PosInBatch)
 
-	var endMsgCount arbutil.MessageIndex
+	// The start and end batches are read concurrently, as they're independent and may each need to be waited for.
+	// Errors are still checked in the same order, so a start batch error takes precedence over an end batch error.
+	var startMsgCount, endMsgCount arbutil.MessageIndex
+	var startErr, endErr error
+	var wg sync.WaitGroup
+	if startGs.Batch > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startMsgCount, startErr = getBatchMessageCountForSetup(ctx, config, logger, inboxTracker, startGs.Batch-1)
+		}()
+	}
 	if maxBatchesRead > 0 {
-		var err error
-		endMsgCount, err = inboxTracker.GetBatchMessageCount(maxBatchesRead - 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			endMsgCount, endErr = getBatchMessageCountForSetup(ctx, config, logger, inboxTracker, maxBatchesRead-1)
+		}()
+	}
+	wg.Wait()
+
+	if startErr != nil {
+		return nil, diagnostics, fmt.Errorf("failed to get challenge start batch %v metadata for start global state %v: %w", startGs.Batch-1, startGs, startErr)
+	}
+	if startGs.PosInBatch > math.MaxUint64-uint64(startMsgCount) {
+		return nil, diagnostics, fmt.Errorf("challenge start global state %v position in batch overflows batch start message count %v", startGs, startMsgCount)
+	}
+	startMsgCount += arbutil.MessageIndex(startGs.PosInBatch)
+	diagnostics.StartMsgCount = startMsgCount
+	diagnostics.HaveStartMsgCount = true
+	if config.VerifyStartBlock {
+		res, err := streamer.ResultAtCount(startMsgCount)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get challenge end batch metadata: %w", err)
+			return nil, diagnostics, fmt.Errorf("failed to get challenge start block result at message count %v: %w", startMsgCount, err)
+		}
+		if res.BlockHash != startGs.BlockHash {
+			return nil, diagnostics, fmt.Errorf("challenge start global state %v has block hash %v but the block at message count %v has hash %v", startGs, startGs.BlockHash, startMsgCount, res.BlockHash)
 		}
 	}
 
+	if endErr != nil {
+		return nil, diagnostics, fmt.Errorf("failed to get challenge end batch %v metadata (max batches read %v): %w", maxBatchesRead-1, maxBatchesRead, endErr)
+	}
+	diagnostics.EndMsgCount = endMsgCount
+	diagnostics.HaveEndMsgCount = true
+	if endMsgCount < startMsgCount {
+		return nil, diagnostics, fmt.Errorf("challenge end message count %v (after %v batches) is before start message count %v", endMsgCount, maxBatchesRead, startMsgCount)
+	}
+	// The too far boundary is one past the end message count's position, which must fit in a position
+	if uint64(endMsgCount-startMsgCount) == math.MaxUint64 {
+		return nil, diagnostics, fmt.Errorf("challenge from message count %v to %v is too long", startMsgCount, endMsgCount)
+	}
+
 	return &BlockChallengeBackend{
+		config:                 config,
+		logger:                 logger,
 		streamer:               streamer,
 		startMsgCount:          startMsgCount,
+		endMsgCount:            endMsgCount,
 		startGs:                startGs,
 		startPosition:          0,
 		endPosition:            math.MaxUint64,
-		endGs:                  validator.GoGlobalStateFromSolidity(initialState.EndState),
+		endGs:                  endGs,
+		initialStartGs:         startGs,
+		initialEndGs:           endGs,
 		inboxTracker:           inboxTracker,
 		tooFarStartsAtPosition: uint64(endMsgCount - startMsgCount + 1),
-	}, nil
+		resultCache:            newSyncLruCache[arbutil.MessageIndex, execution.MessageResult](config.ResultCacheSize),
+	}, diagnostics, nil
+}
+
+func getBatchMessageCountForSetup(
+	ctx context.Context,
+	config *BlockChallengeBackendConfig,
+	logger log.Logger,
+	inboxTracker InboxTrackerInterface,
+	seqNum uint64,
+) (arbutil.MessageIndex, error) {
+	clock := config.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	if config.WaitForBatches {
+		return waitForBatchMessageCount(ctx, clock, logger, inboxTracker, seqNum)
+	}
+	backoff := config.SetupRetryBackoff
+	if backoff == 0 {
+		backoff = defaultSetupRetryBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		msgCount, err := inboxTracker.GetBatchMessageCount(seqNum)
+		if err == nil {
+			return msgCount, nil
+		}
+		if attempt > config.SetupRetries {
+			if attempt > 1 {
+				return 0, fmt.Errorf("failed after %v attempts: %w", attempt, err)
+			}
+			return 0, err
+		}
+		logger.Debug("retrying batch metadata read", "batch", seqNum, "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("gave up retrying batch %v metadata after %v attempts (%w), last error: %w", seqNum, attempt, ctx.Err(), err)
+		case <-clock.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// WaitForBatchMessageCount polls the inbox tracker with exponential backoff until the message count
+// of the given batch is available, or the context is done.
+func WaitForBatchMessageCount(ctx context.Context, inboxTracker InboxTrackerInterface, seqNum uint64) (arbutil.MessageIndex, error) {
+	return waitForBatchMessageCount(ctx, realClock{}, log.Root(), inboxTracker, seqNum)
+}
+
+func waitForBatchMessageCount(ctx context.Context, clock Clock, logger log.Logger, inboxTracker InboxTrackerInterface, seqNum uint64) (arbutil.MessageIndex, error) {
+	backoff := batchWaitInitialBackoff
+	for {
+		msgCount, err := inboxTracker.GetBatchMessageCount(seqNum)
+		if err == nil {
+			return msgCount, nil
+		}
+		logger.Debug("waiting for batch metadata", "batch", seqNum, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("gave up waiting for batch %v metadata (%w), last error: %w", seqNum, ctx.Err(), err)
+		case <-clock.After(backoff):
+		}
+		backoff = min(backoff*2, batchWaitMaxBackoff)
+	}
 }
 
 func (b *BlockChallengeBackend) findBatchAfterMessageCount(msgCount arbutil.MessageIndex) (uint64, error) {
+	batch, err := searchBatchAfterMessageCount(b.inboxTracker, msgCount, b.startGs.Batch, b.endGs.Batch, b.config.StrictBatchOrdering)
+	if err != nil {
+		return 0, err
+	}
+	b.logger.Debug("found batch for block challenge message count", "msgCount", msgCount, "batch", batch, "low", b.startGs.Batch, "high", b.endGs.Batch)
+	return batch, nil
+}
+
+// EndResolvesAtBoundary returns whether the current end global state is exactly on a batch boundary, i.e. at the
+// start of its batch, after all the messages of the previous batch. The batch search's high bound is the end state's
+// batch either way: at a boundary none of that batch's messages are in the range, but the search still returns it
+// for the end message count, as it's the batch after the one whose message count equals it.
+func (b *BlockChallengeBackend) EndResolvesAtBoundary() bool {
+	return b.endGs.PosInBatch == 0
+}
+
+// VerifyBatchMonotonicity reads the message count of every batch the challenge read, checking they're non-decreasing
+// as the batch search assumes. It's a preflight check of the inbox tracker's integrity, returning an error wrapping
+// ErrBatchMessageCountsNotMonotonic that names the first pair of batches out of order.
+func (b *BlockChallengeBackend) VerifyBatchMonotonicity(ctx context.Context) error {
+	var prevMsgCount arbutil.MessageIndex
+	for batch := b.initialStartGs.Batch; batch < b.initialEndGs.Batch; batch++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msgCount, err := b.inboxTracker.GetBatchMessageCount(batch)
+		if err != nil {
+			return fmt.Errorf("failed to get batch %v metadata: %w", batch, err)
+		}
+		if batch > b.initialStartGs.Batch && msgCount < prevMsgCount {
+			return fmt.Errorf("%w: batch %v has message count %v but the previous batch %v has %v", ErrBatchMessageCountsNotMonotonic, batch, msgCount, batch-1, prevMsgCount)
+		}
+		prevMsgCount = msgCount
+	}
+	return nil
+}
+
+// PrefetchBatches reads the message counts of every batch the current range's batch searches could read, so
+// they're cached before bisection needs them. It only helps if the inbox tracker caches them, as the one used
+// by a BlockChallengeBackendFactory does. A failed read doesn't stop the other batches being read, and every
+// failure is returned.
+func (b *BlockChallengeBackend) PrefetchBatches(ctx context.Context) error {
+	first := b.startGs.Batch
+	if first > 0 {
+		first--
+	}
+	// At a batch boundary, the end batch's messages aren't in the range, and it may not have been posted yet
+	last := b.endGs.Batch
+	if b.EndResolvesAtBoundary() && last > first {
+		last--
+	}
+	var errs []error
+	for batch := first; batch <= last; batch++ {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if _, err := b.inboxTracker.GetBatchMessageCount(batch); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prefetch batch %v metadata: %w", batch, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// searchBatchAfterMessageCount binary searches batches low through high for the batch
+// containing the global state after msgCount messages.
+// If strict is set, every message count read is checked against the counts of the batches
+// bounding the search, erroring with ErrBatchMessageCountsNotMonotonic if they're out of order.
+func searchBatchAfterMessageCount(
+	inboxTracker InboxTrackerInterface,
+	msgCount arbutil.MessageIndex,
+	low uint64,
+	high uint64,
+	strict bool,
+) (uint64, error) {
+	if low > high {
+		return 0, fmt.Errorf("can't search for batch of message count %v with low bound %v above high bound %v", msgCount, low, high)
+	}
+	// The global state before any messages is at the inbox origin, position 0 of batch 0, which needs no
+	// metadata. A challenge ending there resolves its end to it rather than erroring.
 	if msgCount == 0 {
 		return 0, nil
 	}
-	low := b.startGs.Batch
-	high := b.endGs.Batch
-	for {
+	// The message counts of batches low-1 and high, so far as they're known
+	var lowMsgCount, highMsgCount arbutil.MessageIndex
+	haveHighMsgCount := false
+	if strict && low > 0 {
+		var err error
+		lowMsgCount, err = inboxTracker.GetBatchMessageCount(low - 1)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get batch metadata while binary searching: %w", err)
+		}
+	}
+	// Every iteration narrows the search, so it terminates even if the metadata violates the invariants below.
+	// Cap the iterations anyway, as hanging the validator would be far worse than returning an error.
+	maxIterations := 64 + bits.Len64(high-low)
+	batchesRead := 0
+	defer func() { batchSearchIterationsHistogram.Update(int64(batchesRead)) }()
+	for iterations := 0; ; iterations++ {
+		if iterations >= maxIterations {
+			return 0, fmt.Errorf("%w: no batch found for message count %v after %v iterations", ErrBatchSearchDidNotConverge, msgCount, iterations)
+		}
 		// Binary search invariants:
 		//   - messageCount(high) >= msgCount
 		//   - messageCount(low-1) < msgCount
@@ -85,19 +585,26 @@ func (b *BlockChallengeBackend) findBatchAfterMessageCount(msgCount arbutil.Mess
 		if high < low {
 			return 0, fmt.Errorf("when attempting to find batch for message count %v high %v < low %v", msgCount, high, low)
 		}
-		mid := (low + high) / 2
-		batchMsgCount, err := b.inboxTracker.GetBatchMessageCount(mid)
+		mid := low + (high-low)/2
+		batchesRead++
+		batchMsgCount, err := inboxTracker.GetBatchMessageCount(mid)
 		if err != nil {
 			return 0, fmt.Errorf("failed to get batch metadata while binary searching: %w", err)
 		}
+		if strict && (batchMsgCount < lowMsgCount || (haveHighMsgCount && batchMsgCount > highMsgCount)) {
+			return 0, fmt.Errorf("%w: batch %v has message count %v outside the range of its neighbors %v to %v", ErrBatchMessageCountsNotMonotonic, mid, batchMsgCount, lowMsgCount, highMsgCount)
+		}
 		if batchMsgCount < msgCount {
 			low = mid + 1
+			lowMsgCount = batchMsgCount
 		} else if batchMsgCount == msgCount {
 			return mid + 1, nil
 		} else if mid == low { // batchMsgCount > msgCount
 			return mid, nil
 		} else { // batchMsgCount > msgCount
 			high = mid
+			highMsgCount = batchMsgCount
+			haveHighMsgCount = true
 		}
 	}
 }
@@ -105,21 +612,94 @@ func (b *BlockChallengeBackend) findBatchAfterMessageCount(msgCount arbutil.Mess
 func (b *BlockChallengeBackend) FindGlobalStateFromMessageCount(count arbutil.MessageIndex) (validator.GoGlobalState, error) {
 	batch, err := b.findBatchAfterMessageCount(count)
 	if err != nil {
-		return validator.GoGlobalState{}, err
+		return validator.GoGlobalState{}, fmt.Errorf("failed to find batch for message count %v: %w", count, err)
+	}
+	return globalStateInBatch(b.resultAtCount, b.inboxTracker, count, batch)
+}
+
+// resultAtCount is the streamer's ResultAtCount, going through the result cache.
+func (b *BlockChallengeBackend) resultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error) {
+	res, ok := b.resultCache.Get(count)
+	if ok {
+		return &res, nil
+	}
+	fetched, err := b.fetchResultAtCount(count)
+	if err != nil {
+		return nil, err
+	}
+	b.resultCache.Add(count, *fetched)
+	return fetched, nil
+}
+
+// fetchResultAtCount gets a block result from the streamer, falling back to the configured fallback source if the
+// streamer doesn't have it. Only the block hash and send root are needed, which the streamer stores per message
+// or the execution engine reads from the block header, so full blocks are never retrieved.
+func (b *BlockChallengeBackend) fetchResultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error) {
+	start := time.Now()
+	res, err := b.streamer.ResultAtCount(count)
+	streamerResultDurationHistogram.Update(time.Since(start).Nanoseconds())
+	if err == nil && res == nil {
+		err = errors.New("streamer returned no block result")
+	}
+	if err == nil || b.config.FallbackResultSource == nil {
+		return res, err
+	}
+	start = time.Now()
+	fallbackRes, fallbackErr := b.config.FallbackResultSource.ResultAtCount(count)
+	fallbackResultDurationHistogram.Update(time.Since(start).Nanoseconds())
+	if fallbackErr == nil && fallbackRes == nil {
+		fallbackErr = errors.New("fallback source returned no block result")
+	}
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("failed to get block result from streamer (%w) or fallback source (%w)", err, fallbackErr)
+	}
+	b.logger.Debug("got block result from fallback source", "msgCount", count, "streamerErr", err)
+	return fallbackRes, nil
+}
+
+// checkResultCache refetches the cached result at a message count, if there is one, and clears the cache if
+// it's changed. Otherwise a reorg wouldn't be caught by SetRange's consistency check, as it'd see the cached result.
+// The reorg may have changed batch message counts too, so any batch cache is also cleared.
+func (b *BlockChallengeBackend) checkResultCache(count arbutil.MessageIndex) error {
+	cached, ok := b.resultCache.Get(count)
+	if !ok {
+		return nil
+	}
+	res, err := b.fetchResultAtCount(count)
+	if err != nil {
+		return fmt.Errorf("failed to get block result at message count %v: %w", count, err)
+	}
+	if *res != cached {
+		b.logger.Warn("block result changed since it was cached, possible reorg", "msgCount", count, "cachedBlockHash", cached.BlockHash, "blockHash", res.BlockHash)
+		b.resultCache.Clear()
+		if b.batchCache != nil {
+			b.batchCache.Clear()
+		}
 	}
+	return nil
+}
+
+// globalStateInBatch returns the global state after count messages, given the batch it's in.
+func globalStateInBatch(
+	resultAtCount func(arbutil.MessageIndex) (*execution.MessageResult, error),
+	inboxTracker InboxTrackerInterface,
+	count arbutil.MessageIndex,
+	batch uint64,
+) (validator.GoGlobalState, error) {
 	var prevBatchMsgCount arbutil.MessageIndex
+	var err error
 	if batch > 0 {
-		prevBatchMsgCount, err = b.inboxTracker.GetBatchMessageCount(batch - 1)
+		prevBatchMsgCount, err = inboxTracker.GetBatchMessageCount(batch - 1)
 		if err != nil {
-			return validator.GoGlobalState{}, err
+			return validator.GoGlobalState{}, fmt.Errorf("failed to get batch %v metadata for message count %v in batch %v: %w", batch-1, count, batch, err)
 		}
 		if prevBatchMsgCount > count {
-			return validator.GoGlobalState{}, errors.New("findBatchFromMessageCount returned bad batch")
+			return validator.GoGlobalState{}, fmt.Errorf("findBatchFromMessageCount returned bad batch %v for message count %v, as the previous batch ends at message count %v", batch, count, prevBatchMsgCount)
 		}
 	}
-	res, err := b.streamer.ResultAtCount(count)
+	res, err := resultAtCount(count)
 	if err != nil {
-		return validator.GoGlobalState{}, err
+		return validator.GoGlobalState{}, fmt.Errorf("failed to get block result at message count %v in batch %v: %w", count, batch, err)
 	}
 	return validator.GoGlobalState{
 		BlockHash:  res.BlockHash,
@@ -129,96 +709,1537 @@ func (b *BlockChallengeBackend) FindGlobalStateFromMessageCount(count arbutil.Me
 	}, nil
 }
 
+// GlobalStateForBlock returns the canonical global state after the given L2 block,
+// independently of any challenge. The block must be in a batch known to the inbox tracker.
+func GlobalStateForBlock(
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+	blockNum uint64,
+) (validator.GoGlobalState, error) {
+	genesisBlockNum := streamer.ChainConfig().ArbitrumChainParams.GenesisBlockNum
+	if blockNum < genesisBlockNum {
+		return validator.GoGlobalState{}, fmt.Errorf("block %v is before genesis block %v", blockNum, genesisBlockNum)
+	}
+	count := arbutil.BlockNumberToMessageCount(blockNum, genesisBlockNum)
+	batchCount, err := inboxTracker.GetBatchCount()
+	if err != nil {
+		return validator.GoGlobalState{}, err
+	}
+	if batchCount == 0 {
+		return validator.GoGlobalState{}, fmt.Errorf("no batches found when looking up block %v", blockNum)
+	}
+	batch, err := searchBatchAfterMessageCount(inboxTracker, count, 0, batchCount-1, false)
+	if err != nil {
+		return validator.GoGlobalState{}, fmt.Errorf("failed to find batch for block %v: %w", blockNum, err)
+	}
+	gs, err := globalStateInBatch(streamer.ResultAtCount, inboxTracker, count, batch)
+	if err != nil {
+		return validator.GoGlobalState{}, fmt.Errorf("failed to get global state after block %v: %w", blockNum, err)
+	}
+	return gs, nil
+}
+
 const StatusFinished uint8 = 1
+
+// StatusErrored is the machine status of an execution that errored. GetInfoAtStep never returns it, as a block
+// that's in the streamer was produced successfully, but GetHashAtStep hashes it as the contract would for completeness.
+const StatusErrored uint8 = 2
 const StatusTooFar uint8 = 3
 
 func (b *BlockChallengeBackend) GetMessageCountAtStep(step uint64) arbutil.MessageIndex {
 	return b.startMsgCount + arbutil.MessageIndex(step)
 }
 
-func (b *BlockChallengeBackend) GetInfoAtStep(step uint64) (validator.GoGlobalState, uint8, error) {
-	msgNum := b.GetMessageCountAtStep(step)
-	if step >= b.tooFarStartsAtPosition {
-		return validator.GoGlobalState{}, StatusTooFar, nil
+// EndMessageCount returns the message count after all the batches the challenged assertion read,
+// which is the message count at the last position before the too far boundary.
+func (b *BlockChallengeBackend) EndMessageCount() arbutil.MessageIndex {
+	return b.endMsgCount
+}
+
+// PositionToMessageCount returns the message count at a position, like GetMessageCountAtStep, but positions past
+// the too far boundary are clamped to it. The too far positions all have the same hash, so there's no message count
+// after the boundary's to look up, and a huge position can't overflow the message count.
+func (b *BlockChallengeBackend) PositionToMessageCount(position uint64) arbutil.MessageIndex {
+	return b.startMsgCount + arbutil.MessageIndex(min(position, b.tooFarStartsAtPosition))
+}
+
+// MessageCountToPosition is the inverse of PositionToMessageCount. It returns false if the message count
+// is before the start of the challenge, or its position would be too far.
+func (b *BlockChallengeBackend) MessageCountToPosition(msgCount arbutil.MessageIndex) (uint64, bool) {
+	if msgCount < b.startMsgCount {
+		return 0, false
 	}
-	globalState, err := b.FindGlobalStateFromMessageCount(msgNum)
-	if err != nil {
-		return validator.GoGlobalState{}, 0, err
+	position := uint64(msgCount - b.startMsgCount)
+	if b.IsTooFar(position) {
+		return 0, false
 	}
-	return globalState, StatusFinished, nil
+	return position, true
 }
 
-func (b *BlockChallengeBackend) SetRange(_ context.Context, start uint64, end uint64) error {
-	if b.startPosition == start && b.endPosition == end {
-		return nil
+// MessagesBetween returns how many messages are between the global states at two positions. As each position is
+// one message after the last, it's the difference between them, but both must be before the too far boundary.
+func (b *BlockChallengeBackend) MessagesBetween(start uint64, end uint64) (uint64, error) {
+	if end < start {
+		return 0, fmt.Errorf("end position %v is before start position %v", end, start)
+	}
+	if b.IsTooFar(end) {
+		return 0, fmt.Errorf("end position %v is too far, the challenge ends at position %v", end, b.tooFarStartsAtPosition-1)
 	}
-	newStartGs, _, err := b.GetInfoAtStep(start)
+	return end - start, nil
+}
+
+// InitialGlobalStates returns the start and end global states the challenge was created with,
+// regardless of how far it's since been bisected.
+func (b *BlockChallengeBackend) InitialGlobalStates() (validator.GoGlobalState, validator.GoGlobalState) {
+	return b.initialStartGs, b.initialEndGs
+}
+
+// CoversBatchRange returns whether the challenge was created from a start global state in startBatch to an end
+// global state in endBatch, to guard against acting on a challenge over different batches than expected.
+func (b *BlockChallengeBackend) CoversBatchRange(startBatch uint64, endBatch uint64) bool {
+	return b.initialStartGs.Batch == startBatch && b.initialEndGs.Batch == endBatch
+}
+
+// SpansSingleBatch returns whether the challenge was created with start and end global states in the same batch.
+// That's legitimate: an assertion can stop part way through a batch, e.g. when it hits its block limit,
+// leaving the next assertion to start and possibly end in the rest of that batch.
+func (b *BlockChallengeBackend) SpansSingleBatch() bool {
+	return b.initialStartGs.Batch == b.initialEndGs.Batch
+}
+
+// VerifyAgainstContract rereads the challenge's start and end global states from its InitiatedChallenge event and
+// checks they match the ones the backend was created with, so a validator doesn't act on a stale view of the challenge.
+// The challenge contract only stores a hash of the current segments, so the event is the only source of the states.
+func (b *BlockChallengeBackend) VerifyAgainstContract(
+	ctx context.Context,
+	l1client bind.ContractBackend,
+	challengeManagerAddr common.Address,
+	startL1Block uint64,
+	challengeIndex uint64,
+) error {
+	states, errs, err := FetchChallengeGlobalStates(ctx, l1client, challengeManagerAddr, startL1Block, []uint64{challengeIndex})
 	if err != nil {
 		return err
 	}
-	newEndGs, endStatus, err := b.GetInfoAtStep(end)
-	if err != nil {
+	if err := errs[challengeIndex]; err != nil {
 		return err
 	}
-	if b.startPosition == start && b.startGs != newStartGs {
-		return fmt.Errorf("challenge start position remains at %v but global state changed from %v to %v", start, b.startGs, newStartGs)
+	return b.verifyInitialGlobalStates(states[challengeIndex][0], states[challengeIndex][1])
+}
+
+// HealthCheck confirms the backend can still serve queries, by checking that the inbox tracker still has the
+// challenge's start batch and the streamer still has its start block, e.g. that neither has been pruned or reorged.
+// It's meant for readiness probes, so only does those two lookups.
+func (b *BlockChallengeBackend) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	b.startGs = newStartGs
-	if endStatus == StatusFinished {
-		b.endGs = newEndGs
+	startGs := b.initialStartGs
+	if startGs.Batch > 0 {
+		prevBatchMsgCount, err := b.inboxTracker.GetBatchMessageCount(startGs.Batch - 1)
+		if err != nil {
+			return fmt.Errorf("failed to get challenge start batch %v metadata: %w", startGs.Batch-1, err)
+		}
+		if expected := b.startMsgCount - arbutil.MessageIndex(startGs.PosInBatch); prevBatchMsgCount != expected {
+			return fmt.Errorf("challenge start batch %v message count changed from %v to %v", startGs.Batch-1, expected, prevBatchMsgCount)
+		}
+	}
+	res, err := b.streamer.ResultAtCount(b.startMsgCount)
+	if err == nil && res == nil {
+		err = errors.New("streamer returned no block result")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get challenge start block result at message count %v: %w", b.startMsgCount, err)
+	}
+	if res.BlockHash != startGs.BlockHash {
+		return fmt.Errorf("challenge start block at message count %v has hash %v but the challenge starts at %v", b.startMsgCount, res.BlockHash, startGs.BlockHash)
 	}
 	return nil
 }
 
-func (b *BlockChallengeBackend) GetHashAtStep(_ context.Context, position uint64) (common.Hash, error) {
-	gs, status, err := b.GetInfoAtStep(position)
-	if err != nil {
-		return common.Hash{}, err
+func (b *BlockChallengeBackend) verifyInitialGlobalStates(startGs validator.GoGlobalState, endGs validator.GoGlobalState) error {
+	if startGs != b.initialStartGs {
+		return fmt.Errorf("%w: start global state is %v but the backend was created with %v", ErrChallengeGlobalStatesChanged, startGs, b.initialStartGs)
 	}
-	if status == StatusFinished {
-		data := []byte("Block state:")
-		data = append(data, gs.Hash().Bytes()...)
-		return crypto.Keccak256Hash(data), nil
-	} else if status == StatusTooFar {
-		return crypto.Keccak256Hash([]byte("Block state, too far:")), nil
-	} else {
-		panic(fmt.Sprintf("Unknown block status: %v", status))
+	if endGs != b.initialEndGs {
+		return fmt.Errorf("%w: end global state is %v but the backend was created with %v", ErrChallengeGlobalStatesChanged, endGs, b.initialEndGs)
 	}
+	return nil
 }
 
-func (b *BlockChallengeBackend) IssueExecChallenge(
-	core *challengeCore,
-	oldState *ChallengeState,
-	startSegment int,
-	numsteps uint64,
-) (*types.Transaction, error) {
-	position := oldState.Segments[startSegment].Position
-	machineStatuses := [2]uint8{}
-	globalStates := [2]validator.GoGlobalState{}
-	var err error
-	globalStates[0], machineStatuses[0], err = b.GetInfoAtStep(position)
-	if err != nil {
-		return nil, err
+// ValidateStatusMonotonicity checks that no step from start to end inclusive is too far while a later step is finished,
+// which would indicate a bug in the too far boundary or corrupt inbox tracker data. Rather than checking every step,
+// it binary searches for where the steps become too far, checking the statuses of the steps it samples on the way.
+func (b *BlockChallengeBackend) ValidateStatusMonotonicity(ctx context.Context, start uint64, end uint64) error {
+	return checkStatusMonotonicity(ctx, start, end, func(position uint64) (uint8, error) {
+		info, err := b.GetInfoAtStep(position)
+		return info.Status, err
+	})
+}
+
+func checkStatusMonotonicity(ctx context.Context, start uint64, end uint64, statusAt func(uint64) (uint8, error)) error {
+	if end < start {
+		return fmt.Errorf("can't validate statuses from step %v to %v", start, end)
 	}
-	globalStates[1], machineStatuses[1], err = b.GetInfoAtStep(position + 1)
-	if err != nil {
-		return nil, err
+	var lastFinished, firstTooFar uint64
+	haveFinished, haveTooFar := false, false
+	sample := func(position uint64) (uint8, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		status, err := statusAt(position)
+		if err != nil {
+			return 0, err
+		}
+		if status == StatusFinished && (!haveFinished || position > lastFinished) {
+			lastFinished, haveFinished = position, true
+		} else if status == StatusTooFar && (!haveTooFar || position < firstTooFar) {
+			firstTooFar, haveTooFar = position, true
+		}
+		if haveFinished && haveTooFar && firstTooFar < lastFinished {
+			return 0, fmt.Errorf("step %v is too far but later step %v is finished", firstTooFar, lastFinished)
+		}
+		return status, nil
 	}
-	globalStateHashes := [2][32]byte{
-		globalStates[0].Hash(),
-		globalStates[1].Hash(),
+	if _, err := sample(start); err != nil {
+		return err
 	}
-	return core.con.ChallengeExecution(
-		core.auth,
-		core.challengeIndex,
-		challengegen.ChallengeLibSegmentSelection{
-			OldSegmentsStart:  oldState.Start,
-			OldSegmentsLength: new(big.Int).Sub(oldState.End, oldState.Start),
-			OldSegments:       oldState.RawSegments,
-			ChallengePosition: big.NewInt(int64(startSegment)),
-		},
-		machineStatuses,
-		globalStateHashes,
+	if _, err := sample(end); err != nil {
+		return err
+	}
+	low, high := start, end
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		status, err := sample(mid)
+		if err != nil {
+			return err
+		}
+		if status == StatusFinished {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return nil
+}
+
+// ProgressFraction approximates how close the challenge is to resolution, from 0 before SetRange is first called
+// to 1 once it's been bisected down to a single step, by how much of the range up to the too far boundary has
+// been bisected away. As bisection narrows the range geometrically, this isn't linear in the moves remaining.
+func (b *BlockChallengeBackend) ProgressFraction() float64 {
+	if b.endPosition == math.MaxUint64 || b.tooFarStartsAtPosition == 0 || b.endPosition < b.startPosition {
+		return 0
+	}
+	fraction := 1 - float64(b.endPosition-b.startPosition)/float64(b.tooFarStartsAtPosition)
+	return max(0, min(1, fraction))
+}
+
+// IsSingleStep returns whether the challenge's range has been bisected down to a single step,
+// at which point an execution challenge should be issued instead of bisecting further.
+// It's false until SetRange is first called, as the range is unbounded until then.
+func (b *BlockChallengeBackend) IsSingleStep() bool {
+	return b.endPosition != math.MaxUint64 && b.endPosition > b.startPosition && b.endPosition-b.startPosition == 1
+}
+
+// IsResolved returns whether the challenge's range has collapsed to a single position, leaving nothing to dispute.
+// The hash at that position can still be computed.
+func (b *BlockChallengeBackend) IsResolved() bool {
+	return b.startPosition == b.endPosition
+}
+
+// RemainingBisectionRounds returns how many more bisections with the given number of segments it'll take to narrow
+// the challenge's range down to a single step, ceil(log_numSegments(end - start)). Before SetRange is first called,
+// the range is taken to end at the too far boundary. Fewer rounds may be needed if the range ends up past the end.
+func (b *BlockChallengeBackend) RemainingBisectionRounds(numSegments uint64) (uint64, error) {
+	if numSegments < 2 {
+		return 0, fmt.Errorf("invalid number of bisection segments %v", numSegments)
+	}
+	end := b.endPosition
+	if end == math.MaxUint64 {
+		end = b.tooFarStartsAtPosition
+	}
+	if end <= b.startPosition {
+		return 0, nil
+	}
+	length := end - b.startPosition
+	var rounds uint64
+	for covered := uint64(1); covered < length; rounds++ {
+		if covered > math.MaxUint64/numSegments {
+			return rounds + 1, nil
+		}
+		covered *= numSegments
+	}
+	return rounds, nil
+}
+
+// ResolutionEstimate returns how many bisection rounds remain as RemainingBisectionRounds does, along with the
+// worst case number of transactions until the challenge is resolved: one per bisection, as each is a move by
+// one party or the other, and the execution challenge of the final single step. A resolved range needs none.
+func (b *BlockChallengeBackend) ResolutionEstimate(numSegments uint64) (rounds uint64, transactions uint64, err error) {
+	rounds, err = b.RemainingBisectionRounds(numSegments)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b.IsResolved() {
+		return 0, 0, nil
+	}
+	return rounds, rounds + 1, nil
+}
+
+// IsTooFar returns whether the given position is past the end of the challenge,
+// in which case its hash is the fixed too far hash and needs no lookups.
+func (b *BlockChallengeBackend) IsTooFar(position uint64) bool {
+	return position >= b.tooFarStartsAtPosition
+}
+
+// EnableStepStatusMetrics starts counting the statuses of the steps queried from this backend,
+// under metrics named after the given challenge index so concurrent challenges don't collide.
+// They stay registered until DisableStepStatusMetrics is called.
+func (b *BlockChallengeBackend) EnableStepStatusMetrics(challengeIndex uint64) {
+	b.DisableStepStatusMetrics()
+	b.stepStatusMetricsPrefix = fmt.Sprintf("arb/validator/challenge/%v/block/steps", challengeIndex)
+	b.finishedStepsCounter = metrics.GetOrRegisterCounter(b.stepStatusMetricsPrefix+"/finished", nil)
+	b.tooFarStepsCounter = metrics.GetOrRegisterCounter(b.stepStatusMetricsPrefix+"/toofar", nil)
+}
+
+// DisableStepStatusMetrics stops counting step statuses and unregisters the counters EnableStepStatusMetrics
+// registered, so a long running staker doesn't accumulate the counters of every challenge it's been in.
+func (b *BlockChallengeBackend) DisableStepStatusMetrics() {
+	if b.stepStatusMetricsPrefix == "" {
+		return
+	}
+	metrics.Unregister(b.stepStatusMetricsPrefix + "/finished")
+	metrics.Unregister(b.stepStatusMetricsPrefix + "/toofar")
+	b.finishedStepsCounter = nil
+	b.tooFarStepsCounter = nil
+	b.stepStatusMetricsPrefix = ""
+}
+
+// StepInfo is the state of a block challenge at a step.
+type StepInfo struct {
+	// GlobalState is the global state after the step, which is only set if Status is StatusFinished
+	GlobalState validator.GoGlobalState
+	Status      uint8
+	// Batch is the batch the global state is in, which is only set if Status is StatusFinished
+	Batch uint64
+}
+
+func (b *BlockChallengeBackend) GetInfoAtStep(step uint64) (StepInfo, error) {
+	msgNum := b.PositionToMessageCount(step)
+	if b.IsTooFar(step) {
+		if b.tooFarStepsCounter != nil {
+			b.tooFarStepsCounter.Inc(1)
+		}
+		b.logger.Debug("block challenge step is too far", "position", step, "msgCount", msgNum, "tooFarStartsAtPosition", b.tooFarStartsAtPosition)
+		return StepInfo{Status: StatusTooFar}, nil
+	}
+	globalState, err := b.FindGlobalStateFromMessageCount(msgNum)
+	if err != nil {
+		return StepInfo{}, fmt.Errorf("failed to get global state at block challenge step %v: %w", step, err)
+	}
+	if b.finishedStepsCounter != nil {
+		b.finishedStepsCounter.Inc(1)
+	}
+	b.logger.Debug("block challenge step is finished", "position", step, "msgCount", msgNum, "batch", globalState.Batch, "posInBatch", globalState.PosInBatch)
+	return StepInfo{GlobalState: globalState, Status: StatusFinished, Batch: globalState.Batch}, nil
+}
+
+// BatchAtStep returns the batch the global state at a position is in, without looking up its block
+// like GetInfoAtStep does. If the position is too far, it returns tooFar rather than a batch.
+func (b *BlockChallengeBackend) BatchAtStep(ctx context.Context, position uint64) (batch uint64, tooFar bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	if b.IsTooFar(position) {
+		return 0, true, nil
+	}
+	batch, err = b.findBatchAfterMessageCount(b.PositionToMessageCount(position))
+	if err != nil {
+		return 0, false, fmt.Errorf("error finding batch at step %v: %w", position, err)
+	}
+	return batch, false, nil
+}
+
+// BatchKind is where a position's batch is within the current range of the challenge.
+type BatchKind uint8
+
+const (
+	// BatchKindInterior is a batch strictly between the range's start and end batches.
+	BatchKindInterior BatchKind = iota
+	// BatchKindStart is the batch of the global state at the start of the range.
+	BatchKindStart
+	// BatchKindEnd is the batch of the global state at the end of the range.
+	BatchKindEnd
+	// BatchKindTooFar is the kind of a position that's too far, which has no batch.
+	BatchKindTooFar
+)
+
+// PositionBatchKind returns whether the batch of the global state at a position is the current range's start or
+// end batch, which have special boundary semantics, or one in between. If the range is within a single batch,
+// as SpansSingleBatch describes, its positions are in the start batch.
+func (b *BlockChallengeBackend) PositionBatchKind(ctx context.Context, position uint64) (BatchKind, error) {
+	batch, tooFar, err := b.BatchAtStep(ctx, position)
+	if err != nil {
+		return 0, err
+	}
+	if tooFar {
+		return BatchKindTooFar, nil
+	}
+	if batch == b.startGs.Batch {
+		return BatchKindStart, nil
+	}
+	if batch == b.endGs.Batch {
+		return BatchKindEnd, nil
+	}
+	return BatchKindInterior, nil
+}
+
+// BlockHashAtStep returns the hash of the block at a position, without the batch search GetInfoAtStep needs
+// to derive the rest of the global state. If the position is too far, it returns tooFar and the zero hash.
+func (b *BlockChallengeBackend) BlockHashAtStep(ctx context.Context, position uint64) (hash common.Hash, tooFar bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return common.Hash{}, false, err
+	}
+	if b.IsTooFar(position) {
+		return common.Hash{}, true, nil
+	}
+	msgCount := b.PositionToMessageCount(position)
+	res, err := b.resultAtCount(msgCount)
+	if err != nil {
+		return common.Hash{}, false, fmt.Errorf("failed to get block result at step %v, message count %v: %w", position, msgCount, err)
+	}
+	return res.BlockHash, false, nil
+}
+
+// VerifyAgainstExpected checks the block hash at each position in expected, e.g. one recorded from a reference
+// implementation, against the backend's, and returns the positions where they differ in ascending order.
+// Positions at or past the too far boundary have no block, so they only match an expected zero hash.
+func (b *BlockChallengeBackend) VerifyAgainstExpected(ctx context.Context, expected map[uint64]common.Hash) (mismatches []uint64, err error) {
+	positions := make([]uint64, 0, len(expected))
+	for position := range expected {
+		positions = append(positions, position)
+	}
+	slices.Sort(positions)
+	for _, position := range positions {
+		hash, _, err := b.BlockHashAtStep(ctx, position)
+		if err != nil {
+			return nil, err
+		}
+		if hash != expected[position] {
+			mismatches = append(mismatches, position)
+		}
+	}
+	return mismatches, nil
+}
+
+// DuplicateBlockHash is a pair of adjacent positions found to have the same block hash.
+type DuplicateBlockHash struct {
+	Position     uint64
+	NextPosition uint64
+	BlockHash    common.Hash
+}
+
+// DetectDuplicateBlockHashes returns every pair of adjacent positions from start up to but excluding end that have
+// the same block hash. Each position is a message after the last, so this should never happen, and when it does
+// it's a sign of a bug like a lookup returning a stale cached block. Too far positions have no block and are skipped.
+func (b *BlockChallengeBackend) DetectDuplicateBlockHashes(ctx context.Context, start uint64, end uint64) ([]DuplicateBlockHash, error) {
+	if end < start {
+		return nil, fmt.Errorf("can't check block hashes from step %v to %v", start, end)
+	}
+	var duplicates []DuplicateBlockHash
+	var prevHash common.Hash
+	havePrev := false
+	for position := start; position < end; position++ {
+		hash, tooFar, err := b.BlockHashAtStep(ctx, position)
+		if err != nil {
+			return nil, err
+		}
+		if tooFar {
+			break
+		}
+		if havePrev && hash == prevHash {
+			duplicates = append(duplicates, DuplicateBlockHash{Position: position - 1, NextPosition: position, BlockHash: hash})
+		}
+		prevHash = hash
+		havePrev = true
+	}
+	return duplicates, nil
+}
+
+// PositionsForBatch returns the inclusive range of positions whose global states are in the given batch,
+// i.e. that are after at least all of the previous batch's messages but not all of the batch's messages.
+// Positions past the too far boundary are excluded, and if no positions are left, ErrBatchOutsideChallenge is returned.
+func (b *BlockChallengeBackend) PositionsForBatch(ctx context.Context, batch uint64) (firstPosition uint64, lastPosition uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	if batch < b.initialStartGs.Batch || batch > b.initialEndGs.Batch {
+		return 0, 0, fmt.Errorf("%w: batch %v isn't within batches %v to %v", ErrBatchOutsideChallenge, batch, b.initialStartGs.Batch, b.initialEndGs.Batch)
+	}
+	endMsgCount := b.endMsgCount
+	var prevBatchMsgCount arbutil.MessageIndex
+	if batch > 0 {
+		prevBatchMsgCount, err = b.inboxTracker.GetBatchMessageCount(batch - 1)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get batch %v metadata: %w", batch-1, err)
+		}
+	}
+	if prevBatchMsgCount > endMsgCount {
+		return 0, 0, fmt.Errorf("%w: batch %v starts after message count %v, the end of the challenge", ErrBatchOutsideChallenge, batch, endMsgCount)
+	}
+	lastMsgCount := endMsgCount
+	// The global state after the challenge's last message is at the start of the batch after the last one read,
+	// which the inbox tracker might not have
+	if prevBatchMsgCount < endMsgCount {
+		batchMsgCount, err := b.inboxTracker.GetBatchMessageCount(batch)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get batch %v metadata: %w", batch, err)
+		}
+		if batchMsgCount <= prevBatchMsgCount || batchMsgCount <= b.startMsgCount {
+			return 0, 0, fmt.Errorf("%w: batch %v ends at message count %v, before the challenge starts at %v", ErrBatchOutsideChallenge, batch, batchMsgCount, b.startMsgCount)
+		}
+		lastMsgCount = min(lastMsgCount, batchMsgCount-1)
+	}
+	firstPosition, ok := b.MessageCountToPosition(max(prevBatchMsgCount, b.startMsgCount))
+	if !ok {
+		return 0, 0, fmt.Errorf("batch %v starts at message count %v, outside the challenge", batch, prevBatchMsgCount)
+	}
+	lastPosition, ok = b.MessageCountToPosition(lastMsgCount)
+	if !ok {
+		return 0, 0, fmt.Errorf("batch %v ends at message count %v, outside the challenge", batch, lastMsgCount)
+	}
+	return firstPosition, lastPosition, nil
+}
+
+func (b *BlockChallengeBackend) SetRange(_ context.Context, start uint64, end uint64) error {
+	if b.startPosition == start && b.endPosition == end {
+		return nil
+	}
+	if start == b.startPosition && !b.IsTooFar(start) {
+		if err := b.checkResultCache(b.PositionToMessageCount(start)); err != nil {
+			return err
+		}
+	}
+	startInfo, err := b.GetInfoAtStep(start)
+	if err != nil {
+		return err
+	}
+	endInfo, err := b.GetInfoAtStep(end)
+	if err != nil {
+		return err
+	}
+	return b.applyRange(start, end, startInfo.GlobalState, endInfo.GlobalState, endInfo.Status)
+}
+
+// SetRangeWithStates is SetRange for callers which already know the global states at the start and end
+// of the range, skipping the batch searches needed to derive them. The states are trusted, unless the
+// VerifySuppliedStates config option is set, in which case they're compared against derived ones.
+// The end state is ignored if the end of the range is too far.
+func (b *BlockChallengeBackend) SetRangeWithStates(
+	_ context.Context,
+	start uint64,
+	end uint64,
+	startGs validator.GoGlobalState,
+	endGs validator.GoGlobalState,
+) error {
+	if b.startPosition == start && b.endPosition == end {
+		return nil
+	}
+	endStatus := StatusFinished
+	if b.IsTooFar(end) {
+		endStatus = StatusTooFar
+	}
+	if b.config.VerifySuppliedStates {
+		derivedStart, err := b.GetInfoAtStep(start)
+		if err != nil {
+			return err
+		}
+		if derivedStart.GlobalState != startGs {
+			return fmt.Errorf("supplied global state %v at challenge start position %v doesn't match derived global state %v", startGs, start, derivedStart.GlobalState)
+		}
+		if endStatus == StatusFinished {
+			derivedEnd, err := b.GetInfoAtStep(end)
+			if err != nil {
+				return err
+			}
+			if derivedEnd.GlobalState != endGs {
+				return fmt.Errorf("supplied global state %v at challenge end position %v doesn't match derived global state %v", endGs, end, derivedEnd.GlobalState)
+			}
+		}
+	}
+	return b.applyRange(start, end, startGs, endGs, endStatus)
+}
+
+func (b *BlockChallengeBackend) applyRange(
+	start uint64,
+	end uint64,
+	newStartGs validator.GoGlobalState,
+	newEndGs validator.GoGlobalState,
+	endStatus uint8,
+) error {
+	if b.startPosition == start && b.startGs != newStartGs {
+		return fmt.Errorf("challenge start position remains at %v but global state changed from %v to %v", start, b.startGs, newStartGs)
+	}
+	b.logger.Debug(
+		"updating block challenge range",
+		"start", start, "end", end, "endStatus", endStatus,
+		"startBatch", newStartGs.Batch, "startPosInBatch", newStartGs.PosInBatch,
+		"endBatch", newEndGs.Batch, "endPosInBatch", newEndGs.PosInBatch,
+	)
+	b.startPosition = start
+	b.endPosition = end
+	b.startGs = newStartGs
+	if endStatus == StatusFinished {
+		b.endGs = newEndGs
+	}
+	return nil
+}
+
+// Subrange creates a backend covering only positions a through b of this one, as though the challenge
+// had already been bisected down to them. Position 0 of the new backend is position a of this one, and
+// positions past b are too far. The backends share their streamer, inbox tracker and result cache.
+// Like NewBlockChallengeBackendFromGlobalStates, it's intended for testing bisection strategies, as the
+// contract wouldn't accept the new backend's too far hashes for positions inside this challenge.
+func (b *BlockChallengeBackend) Subrange(_ context.Context, start uint64, end uint64) (*BlockChallengeBackend, error) {
+	if start >= end {
+		return nil, fmt.Errorf("subrange start position %v isn't before end position %v", start, end)
+	}
+	if start < b.startPosition || end > b.endPosition {
+		return nil, fmt.Errorf("subrange %v to %v isn't within challenge range %v to %v", start, end, b.startPosition, b.endPosition)
+	}
+	if b.IsTooFar(start) {
+		return nil, fmt.Errorf("subrange start position %v is too far", start)
+	}
+	startInfo, err := b.GetInfoAtStep(start)
+	if err != nil {
+		return nil, err
+	}
+	endInfo, err := b.GetInfoAtStep(end)
+	if err != nil {
+		return nil, err
+	}
+	endGs := b.endGs
+	if endInfo.Status == StatusFinished {
+		endGs = endInfo.GlobalState
+	}
+	// The end is MaxUint64 until SetRange is called, so it's only added to if it's before the too far boundary
+	tooFarStartsAtPosition := b.tooFarStartsAtPosition
+	if end < tooFarStartsAtPosition {
+		tooFarStartsAtPosition = end + 1
+	}
+	tooFarStartsAtPosition -= start
+	return &BlockChallengeBackend{
+		config:                 b.config,
+		logger:                 b.logger,
+		streamer:               b.streamer,
+		startMsgCount:          b.PositionToMessageCount(start),
+		endMsgCount:            b.PositionToMessageCount(start + tooFarStartsAtPosition - 1),
+		startGs:                startInfo.GlobalState,
+		startPosition:          0,
+		endPosition:            math.MaxUint64,
+		endGs:                  endGs,
+		initialStartGs:         startInfo.GlobalState,
+		initialEndGs:           endGs,
+		inboxTracker:           b.inboxTracker,
+		tooFarStartsAtPosition: tooFarStartsAtPosition,
+		resultCache:            b.resultCache,
+		batchCache:             b.batchCache,
+	}, nil
+}
+
+func (b *BlockChallengeBackend) GetHashAtStep(_ context.Context, position uint64) (common.Hash, error) {
+	info, err := b.GetInfoAtStep(position)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	hash, err := b.hashBlockState(info.GlobalState, info.Status)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to hash block state at position %v: %w", position, err)
+	}
+	return hash, nil
+}
+
+// BlockStateHashPrefixes are the prefixes hashed with a block state for each of its statuses.
+type BlockStateHashPrefixes struct {
+	Finished string
+	Errored  string
+	TooFar   string
+}
+
+// DefaultBlockStateHashPrefixes are the prefixes the challenge contract uses.
+var DefaultBlockStateHashPrefixes = BlockStateHashPrefixes{
+	Finished: "Block state:",
+	Errored:  "Block state, errored:",
+	TooFar:   "Block state, too far:",
+}
+
+func (p BlockStateHashPrefixes) withDefaults() BlockStateHashPrefixes {
+	if p.Finished == "" {
+		p.Finished = DefaultBlockStateHashPrefixes.Finished
+	}
+	if p.Errored == "" {
+		p.Errored = DefaultBlockStateHashPrefixes.Errored
+	}
+	if p.TooFar == "" {
+		p.TooFar = DefaultBlockStateHashPrefixes.TooFar
+	}
+	return p
+}
+
+// hashBlockState is blockStateHash using the configured hash prefixes and hasher.
+func (b *BlockChallengeBackend) hashBlockState(gs validator.GoGlobalState, status uint8) (common.Hash, error) {
+	return blockStateHashWithPrefixes(b.config.Hasher, b.config.HashPrefixes, gs, status)
+}
+
+// blockStateHash hashes a block state the way the challenge contract does.
+func blockStateHash(gs validator.GoGlobalState, status uint8) (common.Hash, error) {
+	return blockStateHashWithPrefixes(crypto.Keccak256Hash, DefaultBlockStateHashPrefixes, gs, status)
+}
+
+// blockStateHashWithPrefixes hashes a block state as the challenge contract does, but with the given hasher and
+// prefixes. A nil hasher is crypto.Keccak256Hash, and each empty prefix defaults to the contract's.
+func blockStateHashWithPrefixes(hasher validator.Keccak256Hasher, prefixes BlockStateHashPrefixes, gs validator.GoGlobalState, status uint8) (common.Hash, error) {
+	if hasher == nil {
+		hasher = crypto.Keccak256Hash
+	}
+	prefixes = prefixes.withDefaults()
+	if status == StatusFinished {
+		data := []byte(prefixes.Finished)
+		data = append(data, gs.HashWith(hasher).Bytes()...)
+		return hasher(data), nil
+	} else if status == StatusErrored {
+		data := []byte(prefixes.Errored)
+		data = append(data, gs.HashWith(hasher).Bytes()...)
+		return hasher(data), nil
+	} else if status == StatusTooFar {
+		if prefixes.TooFar == DefaultBlockStateHashPrefixes.TooFar {
+			return blockStateTooFarHash, nil
+		}
+		return hasher([]byte(prefixes.TooFar)), nil
+	} else {
+		return common.Hash{}, fmt.Errorf("unknown block status %v", status)
+	}
+}
+
+// maxConcurrentHashLookups bounds how many batch lookups GetHashesAtSteps does at once.
+const maxConcurrentHashLookups = 16
+
+// GetHashesAtSteps returns the hash at each of the given positions, in the same order as the input.
+// The positions are grouped by batch: a bounded pool of workers binary searches the batch of every distinct
+// position concurrently, and then resolves each distinct batch's block results and hashes its positions.
+// The searches read batch metadata through a memo, so each batch's metadata is only read once between them.
+func (b *BlockChallengeBackend) GetHashesAtSteps(ctx context.Context, positions []uint64) ([]common.Hash, error) {
+	inboxTracker := newBatchMessageCountMemo(b.inboxTracker)
+	var distinct []uint64
+	distinctIndex := make(map[uint64]int)
+	for _, position := range positions {
+		if _, ok := distinctIndex[position]; ok || b.IsTooFar(position) {
+			continue
+		}
+		distinctIndex[position] = len(distinct)
+		distinct = append(distinct, position)
+	}
+	batches := make([]uint64, len(distinct))
+	err := forEachBounded(ctx, len(distinct), maxConcurrentHashLookups, func(i int) error {
+		var err error
+		batches[i], err = searchBatchAfterMessageCount(inboxTracker, b.PositionToMessageCount(distinct[i]), b.startGs.Batch, b.endGs.Batch, b.config.StrictBatchOrdering)
+		if err != nil {
+			return fmt.Errorf("error finding batch at step %v: %w", distinct[i], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var batchOrder []uint64
+	positionsByBatch := make(map[uint64][]int)
+	for i, batch := range batches {
+		if _, ok := positionsByBatch[batch]; !ok {
+			batchOrder = append(batchOrder, batch)
+		}
+		positionsByBatch[batch] = append(positionsByBatch[batch], i)
+	}
+	distinctHashes := make([]common.Hash, len(distinct))
+	err = forEachBounded(ctx, len(batchOrder), maxConcurrentHashLookups, func(i int) error {
+		batch := batchOrder[i]
+		for _, j := range positionsByBatch[batch] {
+			gs, err := globalStateInBatch(b.resultAtCount, inboxTracker, b.PositionToMessageCount(distinct[j]), batch)
+			if err != nil {
+				return fmt.Errorf("error getting hash at step %v: %w", distinct[j], err)
+			}
+			distinctHashes[j], err = b.hashBlockState(gs, StatusFinished)
+			if err != nil {
+				return fmt.Errorf("failed to hash block state at position %v: %w", distinct[j], err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]common.Hash, len(positions))
+	for i, position := range positions {
+		j, ok := distinctIndex[position]
+		if !ok {
+			if b.tooFarStepsCounter != nil {
+				b.tooFarStepsCounter.Inc(1)
+			}
+			hashes[i], err = b.hashBlockState(validator.GoGlobalState{}, StatusTooFar)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash block state at position %v: %w", position, err)
+			}
+			continue
+		}
+		if b.finishedStepsCounter != nil {
+			b.finishedStepsCounter.Inc(1)
+		}
+		hashes[i] = distinctHashes[j]
+	}
+	return hashes, nil
+}
+
+// forEachBounded calls fn with every index up to n, running at most limit calls at once. It stops handing out
+// indices once a call fails or the context is cancelled, and returns the first error of the lowest index.
+func forEachBounded(ctx context.Context, n int, limit int, fn func(i int) error) error {
+	errs := make([]error, n)
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(limit, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = fn(i)
+				if errs[i] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case work <- i:
+		case <-workCtx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// batchMessageCountMemo is an inbox tracker that reads each batch's message count at most once, even when it's
+// asked for concurrently. Unlike a factory's cache, it's meant to be dropped after a single lookup of many positions,
+// so it never needs invalidating.
+type batchMessageCountMemo struct {
+	InboxTrackerInterface
+	mutex  sync.Mutex
+	counts map[uint64]*memoizedBatchMessageCount
+}
+
+type memoizedBatchMessageCount struct {
+	once     sync.Once
+	msgCount arbutil.MessageIndex
+	err      error
+}
+
+func newBatchMessageCountMemo(inboxTracker InboxTrackerInterface) *batchMessageCountMemo {
+	return &batchMessageCountMemo{
+		InboxTrackerInterface: inboxTracker,
+		counts:                make(map[uint64]*memoizedBatchMessageCount),
+	}
+}
+
+func (m *batchMessageCountMemo) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	m.mutex.Lock()
+	memoized, ok := m.counts[seqNum]
+	if !ok {
+		memoized = &memoizedBatchMessageCount{}
+		m.counts[seqNum] = memoized
+	}
+	m.mutex.Unlock()
+	memoized.once.Do(func() {
+		memoized.msgCount, memoized.err = m.InboxTrackerInterface.GetBatchMessageCount(seqNum)
+	})
+	return memoized.msgCount, memoized.err
+}
+
+// GetHashRange returns the hash at every position from start up to but excluding end.
+// Consecutive positions are usually in the same batch, so rather than binary searching the batch of every position
+// like GetHashAtStep, it binary searches once and then advances through the batches as it goes.
+func (b *BlockChallengeBackend) GetHashRange(ctx context.Context, start uint64, end uint64) ([]common.Hash, error) {
+	if end < start {
+		return nil, fmt.Errorf("can't get hashes from step %v to %v", start, end)
+	}
+	hashes := make([]common.Hash, 0, end-start)
+	err := b.walkRange(ctx, start, end, func(_ uint64, gs validator.GoGlobalState, status uint8) error {
+		hash, err := b.hashBlockState(gs, status)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// WalkSegment passes the hash and status of every position from start through end, inclusive, to fn in order,
+// stopping early if fn returns an error or the context is cancelled. Like GetHashRange, it advances through the
+// batches rather than binary searching the batch of every position, so it's suited to walking a bisected segment
+// step by step to find where it diverges.
+func (b *BlockChallengeBackend) WalkSegment(ctx context.Context, start uint64, end uint64, fn func(position uint64, hash common.Hash, status uint8) error) error {
+	_, _, err := b.WalkSegmentWithBudget(ctx, start, end, math.MaxUint64, fn)
+	return err
+}
+
+// WalkSegmentWithBudget is WalkSegment walking at most budget positions from start. It returns the position to
+// resume from by passing it as start, and whether the walk reached end.
+func (b *BlockChallengeBackend) WalkSegmentWithBudget(
+	ctx context.Context,
+	start uint64,
+	end uint64,
+	budget uint64,
+	fn func(position uint64, hash common.Hash, status uint8) error,
+) (next uint64, done bool, err error) {
+	if end < start || end == math.MaxUint64 {
+		return 0, false, fmt.Errorf("can't walk steps %v to %v", start, end)
+	}
+	if budget == 0 {
+		return 0, false, errors.New("step budget must be non-zero")
+	}
+	next = start + min(budget, end-start+1)
+	err = b.walkRange(ctx, start, next, func(position uint64, gs validator.GoGlobalState, status uint8) error {
+		hash, err := b.hashBlockState(gs, status)
+		if err != nil {
+			return fmt.Errorf("failed to hash block state at position %v: %w", position, err)
+		}
+		return fn(position, hash, status)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return next, next > end, nil
+}
+
+// maxHashIndexSteps caps the range BuildHashIndex will index. Each entry costs around 100 bytes,
+// so the largest index is around 100MB.
+const maxHashIndexSteps = 1 << 20
+
+// BuildHashIndex computes the hash of every position from start through end, inclusive, and maps each back to its
+// position, so many hashes seen on-chain can be correlated to positions without rescanning the range for each.
+// Every too far position has the same hash, which maps to the first of them. The whole index is held in memory,
+// so ranges of more than maxHashIndexSteps positions are rejected.
+func (b *BlockChallengeBackend) BuildHashIndex(ctx context.Context, start uint64, end uint64) (map[common.Hash]uint64, error) {
+	if end < start || end-start >= maxHashIndexSteps {
+		return nil, fmt.Errorf("can't index steps %v to %v, at most %v steps can be indexed", start, end, maxHashIndexSteps)
+	}
+	index := make(map[common.Hash]uint64, end-start+1)
+	err := b.WalkSegment(ctx, start, end, func(position uint64, hash common.Hash, _ uint8) error {
+		if _, ok := index[hash]; !ok {
+			index[hash] = position
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// walkRange passes the global state and status of every position from start up to but excluding end to fn in order.
+// It binary searches the batch of the first position that isn't too far, and then advances through the batches.
+func (b *BlockChallengeBackend) walkRange(ctx context.Context, start uint64, end uint64, fn func(position uint64, gs validator.GoGlobalState, status uint8) error) error {
+	return b.walkBatches(ctx, start, end, func(position uint64, batch uint64, posInBatch uint64) error {
+		if b.IsTooFar(position) {
+			return fn(position, validator.GoGlobalState{}, StatusTooFar)
+		}
+		msgCount := b.PositionToMessageCount(position)
+		res, err := b.resultAtCount(msgCount)
+		if err != nil {
+			return fmt.Errorf("failed to get block result at message count %v in batch %v: %w", msgCount, batch, err)
+		}
+		gs := validator.GoGlobalState{
+			BlockHash:  res.BlockHash,
+			SendRoot:   res.SendRoot,
+			Batch:      batch,
+			PosInBatch: posInBatch,
+		}
+		return fn(position, gs, StatusFinished)
+	})
+}
+
+// walkBatches passes the batch and position in batch of the global state at every position from start up to but
+// excluding end to fn in order, without looking up blocks. Too far positions are passed with a zero batch.
+func (b *BlockChallengeBackend) walkBatches(ctx context.Context, start uint64, end uint64, fn func(position uint64, batch uint64, posInBatch uint64) error) error {
+	haveBatch := false
+	var batch uint64
+	var prevBatchMsgCount, batchMsgCount arbutil.MessageIndex
+	haveBatchMsgCount := false
+	for position := start; position < end; position++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if b.IsTooFar(position) {
+			if err := fn(position, 0, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		msgCount := b.PositionToMessageCount(position)
+		if !haveBatch {
+			var err error
+			batch, err = b.findBatchAfterMessageCount(msgCount)
+			if err != nil {
+				return fmt.Errorf("error finding batch at step %v: %w", position, err)
+			}
+			if batch > 0 {
+				prevBatchMsgCount, err = b.inboxTracker.GetBatchMessageCount(batch - 1)
+				if err != nil {
+					return err
+				}
+			}
+			haveBatch = true
+		}
+		// As in the binary search, the state after a batch's last message is at the start of the next batch.
+		// Once a position is at the start of a batch, that batch's message count isn't read until a later position
+		// needs it, so a challenge ending after its last batch's messages doesn't read the batch after them.
+		for prevBatchMsgCount < msgCount {
+			if !haveBatchMsgCount {
+				var err error
+				batchMsgCount, err = b.inboxTracker.GetBatchMessageCount(batch)
+				if err != nil {
+					return fmt.Errorf("failed to get batch %v metadata at step %v: %w", batch, position, err)
+				}
+				haveBatchMsgCount = true
+			}
+			if batchMsgCount > msgCount {
+				break
+			}
+			batch++
+			prevBatchMsgCount = batchMsgCount
+			haveBatchMsgCount = false
+		}
+		if prevBatchMsgCount > msgCount {
+			return fmt.Errorf("batch %v starts after message count %v at step %v", batch, msgCount, position)
+		}
+		if err := fn(position, batch, uint64(msgCount-prevBatchMsgCount)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DistinctBatchesInRange returns the batches of the global states at positions start through end, inclusive,
+// in ascending order without duplicates, e.g. to estimate the metadata lookups a bisection response needs.
+// Too far positions are excluded. Like WalkSegment, it advances through the batches rather than binary searching
+// the batch of every position, and as it doesn't need blocks, it doesn't look any up.
+func (b *BlockChallengeBackend) DistinctBatchesInRange(ctx context.Context, start uint64, end uint64) ([]uint64, error) {
+	if end < start {
+		return nil, fmt.Errorf("can't find batches from step %v to %v", start, end)
+	}
+	end = min(end, b.tooFarStartsAtPosition-1)
+	var batches []uint64
+	if end < start || b.IsTooFar(start) {
+		return batches, nil
+	}
+	err := b.walkBatches(ctx, start, end+1, func(_ uint64, batch uint64, _ uint64) error {
+		if len(batches) == 0 || batches[len(batches)-1] != batch {
+			batches = append(batches, batch)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// checkSegmentSelection verifies that oldState is consistent with what the challenge contract expects
+// before a segment of it is selected on-chain. The contract re-hashes the submitted OldSegments and
+// requires the result to match its stored challenge state hash, so RawSegments must be exactly the
+// chain hashes it committed to: one hash per segment boundary, matching Segments one-to-one.
+// It also requires at least two segments, and that the selected segment is not the last boundary.
+func checkSegmentSelection(oldState *ChallengeState, startSegment int) error {
+	if len(oldState.RawSegments) != len(oldState.Segments) {
+		return fmt.Errorf("challenge state has %v raw segments but %v segments", len(oldState.RawSegments), len(oldState.Segments))
+	}
+	for i, segment := range oldState.Segments {
+		if segment.Hash != oldState.RawSegments[i] {
+			return fmt.Errorf("challenge state segment %v hash %v doesn't match raw segment %v", i, segment.Hash, common.Hash(oldState.RawSegments[i]))
+		}
+	}
+	if len(oldState.Segments) < 2 {
+		return fmt.Errorf("challenge state has %v segments but at least 2 are required", len(oldState.Segments))
+	}
+	if startSegment < 0 || startSegment >= len(oldState.Segments)-1 {
+		return fmt.Errorf("challenge segment %v out of range for %v segments", startSegment, len(oldState.Segments))
+	}
+	return nil
+}
+
+// Replay walks every position of the challenge that isn't too far, in order, passing each position's
+// global state and status to fn. It stops early if fn returns an error or the context is cancelled.
+func (b *BlockChallengeBackend) Replay(ctx context.Context, fn func(position uint64, gs validator.GoGlobalState, status uint8) error) error {
+	_, _, err := b.ReplayWithBudget(ctx, 0, math.MaxUint64, fn)
+	return err
+}
+
+// ReplayWithBudget is Replay resuming from position from, which walks at most budget positions so a scheduler
+// can interleave a long replay with other work. It returns the position to resume from, and whether the replay
+// is done. Resuming until done passes fn the same positions as an uninterrupted Replay.
+func (b *BlockChallengeBackend) ReplayWithBudget(
+	ctx context.Context,
+	from uint64,
+	budget uint64,
+	fn func(position uint64, gs validator.GoGlobalState, status uint8) error,
+) (next uint64, done bool, err error) {
+	if budget == 0 {
+		return 0, false, errors.New("step budget must be non-zero")
+	}
+	if from >= b.tooFarStartsAtPosition {
+		return from, true, nil
+	}
+	next = from + min(budget, b.tooFarStartsAtPosition-from)
+	if err := b.walkRange(ctx, from, next, fn); err != nil {
+		return 0, false, fmt.Errorf("error replaying block challenge from step %v: %w", from, err)
+	}
+	return next, next == b.tooFarStartsAtPosition, nil
+}
+
+// FindFirstDivergence computes the hash at each of the given positions and compares it against the
+// claimed hash at the same index, returning the lowest position where they differ, if any.
+func (b *BlockChallengeBackend) FindFirstDivergence(ctx context.Context, positions []uint64, claimedHashes []common.Hash) (uint64, bool, error) {
+	if len(positions) != len(claimedHashes) {
+		return 0, false, fmt.Errorf("got %v positions but %v claimed hashes", len(positions), len(claimedHashes))
+	}
+	hashes, err := b.GetHashesAtSteps(ctx, positions)
+	if err != nil {
+		return 0, false, err
+	}
+	var divergence uint64
+	diverged := false
+	for i, hash := range hashes {
+		if hash != claimedHashes[i] && (!diverged || positions[i] < divergence) {
+			divergence = positions[i]
+			diverged = true
+		}
+	}
+	return divergence, diverged, nil
+}
+
+// compareBackendsChunkSize is how many positions CompareBackends hashes from each backend at a time.
+const compareBackendsChunkSize = 256
+
+// CompareBackends computes the hashes of two backends at every position from start through end, inclusive,
+// and returns the first position where they differ, if any. It's for checking two nodes, or two versions
+// of a node, agree on a challenge. Positions are hashed in chunks, so it stops soon after a divergence.
+func CompareBackends(ctx context.Context, a *BlockChallengeBackend, b *BlockChallengeBackend, start uint64, end uint64) (firstDivergence uint64, diverged bool, err error) {
+	if end < start || end == math.MaxUint64 {
+		return 0, false, fmt.Errorf("can't compare steps %v to %v", start, end)
+	}
+	for chunkStart := start; chunkStart <= end; {
+		chunkEnd := chunkStart + min(compareBackendsChunkSize, end-chunkStart+1)
+		aHashes, err := a.GetHashRange(ctx, chunkStart, chunkEnd)
+		if err != nil {
+			return 0, false, fmt.Errorf("error getting first backend's hashes: %w", err)
+		}
+		bHashes, err := b.GetHashRange(ctx, chunkStart, chunkEnd)
+		if err != nil {
+			return 0, false, fmt.Errorf("error getting second backend's hashes: %w", err)
+		}
+		for i := range aHashes {
+			if aHashes[i] != bHashes[i] {
+				return chunkStart + uint64(i), true, nil
+			}
+		}
+		chunkStart = chunkEnd
+	}
+	return 0, false, nil
+}
+
+// SegmentsHash computes the hashes at the boundaries of a bisection of start to end into numSegments segments,
+// spaced as the challenge contract expects, along with the challenge state hash the contract would store for them.
+func (b *BlockChallengeBackend) SegmentsHash(ctx context.Context, start uint64, end uint64, numSegments uint64) ([]common.Hash, common.Hash, error) {
+	if end < start || numSegments == 0 || numSegments > end-start {
+		return nil, common.Hash{}, fmt.Errorf("can't bisect steps %v to %v into %v segments", start, end, numSegments)
+	}
+	hashes, err := b.GetHashesAtSteps(ctx, segmentPositions(start, end, numSegments))
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	root := hashChallengeState(new(big.Int).SetUint64(start), new(big.Int).SetUint64(end-start), hashes)
+	return hashes, root, nil
+}
+
+// BisectionGlobalStateHashes computes the hashes of the global states at the boundaries of a bisection of
+// start to end into numSegments segments, spaced as the challenge contract expects like SegmentsHash.
+// Unlike the step hashes, these don't cover the block status, so every boundary must be before the too far boundary.
+func (b *BlockChallengeBackend) BisectionGlobalStateHashes(ctx context.Context, start uint64, end uint64, numSegments uint64) ([][32]byte, error) {
+	if end < start || numSegments == 0 || numSegments > end-start {
+		return nil, fmt.Errorf("can't bisect steps %v to %v into %v segments", start, end, numSegments)
+	}
+	positions := segmentPositions(start, end, numSegments)
+	hashes := make([][32]byte, len(positions))
+	for i, position := range positions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info, err := b.GetInfoAtStep(position)
+		if err != nil {
+			return nil, err
+		}
+		if info.Status != StatusFinished {
+			return nil, fmt.Errorf("block challenge step %v has no global state as it's at or past the too far boundary %v", position, b.tooFarStartsAtPosition)
+		}
+		hashes[i] = info.GlobalState.HashWith(b.config.Hasher)
+	}
+	return hashes, nil
+}
+
+// ChallengeStateRoot computes the challenge state hash the challenge contract would store for the given segments,
+// so a local view of the segments can be checked against the contract's before spending gas acting on it.
+// The segments must be in order of strictly increasing position, as the contract stores them.
+func (b *BlockChallengeBackend) ChallengeStateRoot(_ context.Context, segments []ChallengeSegment) (common.Hash, error) {
+	if len(segments) < 2 {
+		return common.Hash{}, fmt.Errorf("challenge state has %v segments but at least 2 are required", len(segments))
+	}
+	hashes := make([]common.Hash, len(segments))
+	for i, segment := range segments {
+		if i > 0 && segment.Position <= segments[i-1].Position {
+			return common.Hash{}, fmt.Errorf("challenge segment %v position %v isn't after the previous segment's position %v", i, segment.Position, segments[i-1].Position)
+		}
+		hashes[i] = segment.Hash
+	}
+	start := segments[0].Position
+	length := segments[len(segments)-1].Position - start
+	return hashChallengeState(new(big.Int).SetUint64(start), new(big.Int).SetUint64(length), hashes), nil
+}
+
+// VerifyBisection compares an opponent's bisection of start to end into numSegments segments against our own
+// hashes at the same boundaries, returning the index of the first boundary whose hash differs, or ok if they
+// all match. The segment to dispute next is the one ending at the first mismatch.
+func (b *BlockChallengeBackend) VerifyBisection(
+	ctx context.Context,
+	start uint64,
+	end uint64,
+	numSegments uint64,
+	opponentHashes []common.Hash,
+) (firstMismatch int, ok bool, err error) {
+	if uint64(len(opponentHashes)) != numSegments+1 {
+		return 0, false, fmt.Errorf("got %v opponent hashes for %v segments, expected %v", len(opponentHashes), numSegments, numSegments+1)
+	}
+	hashes, _, err := b.SegmentsHash(ctx, start, end, numSegments)
+	if err != nil {
+		return 0, false, err
+	}
+	for i, hash := range hashes {
+		if hash != opponentHashes[i] {
+			return i, false, nil
+		}
+	}
+	return 0, true, nil
+}
+
+// BisectionRange is one bisected range in a BisectionTree, with the positions and hashes of its segment boundaries.
+type BisectionRange struct {
+	Start     uint64
+	End       uint64
+	Positions []uint64
+	Hashes    []common.Hash
+}
+
+// BisectionLevel is every range bisected at one depth of a BisectionTree.
+type BisectionLevel struct {
+	Depth  uint64
+	Ranges []BisectionRange
+}
+
+// BisectionTree computes every range a challenge could bisect down to, to a depth of maxDepth levels,
+// along with their segment boundary positions and hashes. Each range is bisected into numSegments segments
+// spaced as the challenge contract expects, or one per step if it's shorter than that, and every segment longer
+// than a single step becomes a range at the next depth. The root is the backend's current range, or if SetRange
+// hasn't been called, the positions up to the too far boundary.
+// The tree grows exponentially with depth, so maxDepth should be small.
+func (b *BlockChallengeBackend) BisectionTree(ctx context.Context, numSegments uint64, maxDepth uint64) ([]BisectionLevel, error) {
+	if numSegments == 0 {
+		return nil, errors.New("can't bisect into zero segments")
+	}
+	end := b.endPosition
+	if end == math.MaxUint64 {
+		end = b.tooFarStartsAtPosition
+	}
+	if end <= b.startPosition {
+		return nil, fmt.Errorf("can't bisect steps %v to %v", b.startPosition, end)
+	}
+	ranges := []BisectionRange{{Start: b.startPosition, End: end}}
+	var levels []BisectionLevel
+	for depth := uint64(0); depth < maxDepth && len(ranges) > 0; depth++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var positions []uint64
+		for i := range ranges {
+			degree := min(numSegments, ranges[i].End-ranges[i].Start)
+			ranges[i].Positions = segmentPositions(ranges[i].Start, ranges[i].End, degree)
+			positions = append(positions, ranges[i].Positions...)
+		}
+		hashes, err := b.GetHashesAtSteps(ctx, positions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hashes at bisection depth %v: %w", depth, err)
+		}
+		var nextRanges []BisectionRange
+		for i := range ranges {
+			ranges[i].Hashes, hashes = hashes[:len(ranges[i].Positions)], hashes[len(ranges[i].Positions):]
+			for j := 1; j < len(ranges[i].Positions); j++ {
+				segmentStart, segmentEnd := ranges[i].Positions[j-1], ranges[i].Positions[j]
+				if segmentEnd-segmentStart > 1 {
+					nextRanges = append(nextRanges, BisectionRange{Start: segmentStart, End: segmentEnd})
+				}
+			}
+		}
+		levels = append(levels, BisectionLevel{Depth: depth, Ranges: ranges})
+		ranges = nextRanges
+	}
+	return levels, nil
+}
+
+// execChallengeArgs are the arguments of a ChallengeExecution call, besides the challenge index and step count.
+type execChallengeArgs struct {
+	selection         challengegen.ChallengeLibSegmentSelection
+	globalStates      [2]validator.GoGlobalState
+	machineStatuses   [2]uint8
+	globalStateHashes [2][32]byte
+}
+
+func (b *BlockChallengeBackend) getExecChallengeArgs(oldState *ChallengeState, startSegment int) (*execChallengeArgs, error) {
+	if err := checkSegmentSelection(oldState, startSegment); err != nil {
+		return nil, err
+	}
+	return b.buildExecChallengeArgs(oldState, startSegment)
+}
+
+// getExecChallengeArgsAt is getExecChallengeArgs, but errors unless position is the step the selected segment proves.
+func (b *BlockChallengeBackend) getExecChallengeArgsAt(oldState *ChallengeState, startSegment int, position uint64) (*execChallengeArgs, error) {
+	if err := checkSegmentSelection(oldState, startSegment); err != nil {
+		return nil, err
+	}
+	if segmentStart := oldState.Segments[startSegment].Position; position != segmentStart {
+		return nil, fmt.Errorf("step %v isn't the step %v proven by an exec challenge of challenge segment %v", position, segmentStart, startSegment)
+	}
+	return b.buildExecChallengeArgs(oldState, startSegment)
+}
+
+// buildExecChallengeArgs builds the arguments of an execution challenge of the selected segment,
+// whose selection must already have been checked by checkSegmentSelection.
+func (b *BlockChallengeBackend) buildExecChallengeArgs(oldState *ChallengeState, startSegment int) (*execChallengeArgs, error) {
+	segmentStart, segmentEnd := oldState.Segments[startSegment].Position, oldState.Segments[startSegment+1].Position
+	// An execution challenge proves a single block, so the selected segment must have been bisected down to one step
+	if segmentEnd != segmentStart+1 {
+		return nil, fmt.Errorf("challenge segment %v spans steps %v to %v but an exec challenge requires a single step", startSegment, segmentStart, segmentEnd)
+	}
+	// The segments are copied, so the caller modifying its challenge state can't corrupt what's submitted
+	args := &execChallengeArgs{
+		selection: challengegen.ChallengeLibSegmentSelection{
+			OldSegmentsStart:  new(big.Int).Set(oldState.Start),
+			OldSegmentsLength: new(big.Int).Sub(oldState.End, oldState.Start),
+			OldSegments:       append([][32]byte(nil), oldState.RawSegments...),
+			ChallengePosition: big.NewInt(int64(startSegment)),
+		},
+	}
+	for i := range args.globalStates {
+		info, err := b.GetInfoAtStep(segmentStart + uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		args.globalStates[i], args.machineStatuses[i] = info.GlobalState, info.Status
+		args.globalStateHashes[i] = info.GlobalState.HashWith(b.config.Hasher)
+	}
+	return args, nil
+}
+
+func (b *BlockChallengeBackend) IssueExecChallenge(
+	core *challengeCore,
+	oldState *ChallengeState,
+	startSegment int,
+	numsteps uint64,
+) (*types.Transaction, error) {
+	args, err := b.getExecChallengeArgs(oldState, startSegment)
+	if err != nil {
+		return nil, err
+	}
+	return b.submitExecChallenge(core, oldState, startSegment, args, numsteps)
+}
+
+// IssueExecChallengeAt is IssueExecChallenge, but takes the position where the caller found our view diverges
+// from the opponent's. The challenge contract only allows an execution challenge of a single step segment,
+// which always proves the step at the segment's start, so the position can't move the proof. Instead it's
+// checked as an assertion: if it isn't the segment's start, the caller's divergence doesn't match the segment
+// being challenged, and an error is returned rather than challenging a step the caller didn't intend.
+func (b *BlockChallengeBackend) IssueExecChallengeAt(
+	core *challengeCore,
+	oldState *ChallengeState,
+	startSegment int,
+	numsteps uint64,
+	position uint64,
+) (*types.Transaction, error) {
+	args, err := b.getExecChallengeArgsAt(oldState, startSegment, position)
+	if err != nil {
+		return nil, err
+	}
+	return b.submitExecChallenge(core, oldState, startSegment, args, numsteps)
+}
+
+func (b *BlockChallengeBackend) submitExecChallenge(
+	core *challengeCore,
+	oldState *ChallengeState,
+	startSegment int,
+	args *execChallengeArgs,
+	numsteps uint64,
+) (*types.Transaction, error) {
+	if b.config.VerifyBeforeSubmit {
+		callOpts := &bind.CallOpts{}
+		if core.auth != nil {
+			callOpts.Context = core.auth.Context
+		}
+		challenge, err := core.con.ChallengeInfo(callOpts, core.challengeIndex)
+		if err != nil {
+			return nil, fmt.Errorf("error getting challenge %v info to verify exec challenge: %w", core.challengeIndex, err)
+		}
+		if err := b.verifyExecChallengeArgs(oldState, startSegment, args, common.Hash(challenge.ChallengeStateHash)); err != nil {
+			return nil, err
+		}
+	}
+	return core.con.ChallengeExecution(
+		core.auth,
+		core.challengeIndex,
+		args.selection,
+		args.machineStatuses,
+		args.globalStateHashes,
+		new(big.Int).SetUint64(numsteps),
+	)
+}
+
+// verifyExecChallengeArgs checks an execution challenge would pass the challenge contract's checks: that the old
+// segments are the ones it committed to, that we agree with the selected segment's start, and that we disagree
+// with its end. Otherwise the transaction would revert, wasting its gas.
+func (b *BlockChallengeBackend) verifyExecChallengeArgs(
+	oldState *ChallengeState,
+	startSegment int,
+	args *execChallengeArgs,
+	onChainStateHash common.Hash,
+) error {
+	segments := make([]common.Hash, len(oldState.RawSegments))
+	for i, segment := range oldState.RawSegments {
+		segments[i] = segment
+	}
+	stateHash := hashChallengeState(oldState.Start, new(big.Int).Sub(oldState.End, oldState.Start), segments)
+	if stateHash != onChainStateHash {
+		return fmt.Errorf("challenge state hash %v of the segments doesn't match the contract's %v", stateHash, onChainStateHash)
+	}
+	for i := range args.globalStates {
+		hash, err := b.hashBlockState(args.globalStates[i], args.machineStatuses[i])
+		if err != nil {
+			return err
+		}
+		segmentHash := common.Hash(oldState.RawSegments[startSegment+i])
+		if i == 0 && hash != segmentHash {
+			return fmt.Errorf("our hash %v at the start of challenge segment %v doesn't match the segment's %v", hash, startSegment, segmentHash)
+		}
+		if i == 1 && hash == segmentHash {
+			return fmt.Errorf("our hash %v at the end of challenge segment %v matches the segment's, so there's nothing to challenge", hash, startSegment)
+		}
+	}
+	return nil
+}
+
+// ExecChallengeSubmission is an execution challenge transaction along with what it submitted,
+// so it can be logged and later correlated with its receipt.
+type ExecChallengeSubmission struct {
+	Transaction *types.Transaction
+	// Position is the step proven, with GlobalStates and MachineStatuses at it and the step after it
+	Position        uint64
+	GlobalStates    [2]validator.GoGlobalState
+	MachineStatuses [2]uint8
+}
+
+// SubmitExecChallenge is IssueExecChallenge, but returns the global states and statuses submitted
+// along with the transaction.
+func (b *BlockChallengeBackend) SubmitExecChallenge(
+	core *challengeCore,
+	oldState *ChallengeState,
+	startSegment int,
+	numsteps uint64,
+) (*ExecChallengeSubmission, error) {
+	args, err := b.getExecChallengeArgs(oldState, startSegment)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := b.submitExecChallenge(core, oldState, startSegment, args, numsteps)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecChallengeSubmission{
+		Transaction:     tx,
+		Position:        oldState.Segments[startSegment].Position,
+		GlobalStates:    args.globalStates,
+		MachineStatuses: args.machineStatuses,
+	}, nil
+}
+
+// ExecChallengeSummary describes what IssueExecChallenge would submit, for an audit log kept alongside
+// the transaction hash. It derives the global states the same way, so always matches what's submitted.
+func (b *BlockChallengeBackend) ExecChallengeSummary(oldState *ChallengeState, startSegment int) (string, error) {
+	args, err := b.getExecChallengeArgs(oldState, startSegment)
+	if err != nil {
+		return "", err
+	}
+	position := oldState.Segments[startSegment].Position
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "exec challenge of challenge segment %v, at step %v\n", startSegment, position)
+	for i := range args.globalStates {
+		gs := args.globalStates[i]
+		fmt.Fprintf(
+			&summary,
+			"step %v: status %v, block hash %v, send root %v, batch %v, position in batch %v, global state hash %v\n",
+			position+uint64(i), args.machineStatuses[i], gs.BlockHash, gs.SendRoot, gs.Batch, gs.PosInBatch, common.Hash(args.globalStateHashes[i]),
+		)
+	}
+	return summary.String(), nil
+}
+
+// ExecChallengeCalldata returns the calldata IssueExecChallenge would submit to the challenge manager,
+// without signing or sending a transaction, so it can be decoded and inspected beforehand.
+func (b *BlockChallengeBackend) ExecChallengeCalldata(
+	challengeIndex uint64,
+	oldState *ChallengeState,
+	startSegment int,
+	numsteps uint64,
+) ([]byte, error) {
+	args, err := b.getExecChallengeArgs(oldState, startSegment)
+	if err != nil {
+		return nil, err
+	}
+	parsedABI, err := challengegen.ChallengeManagerMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return parsedABI.Pack(
+		"challengeExecution",
+		challengeIndex,
+		args.selection,
+		args.machineStatuses,
+		args.globalStateHashes,
 		new(big.Int).SetUint64(numsteps),
 	)
 }
@@ -0,0 +1,78 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"context"
+
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+)
+
+// BlockChallengeBackendFactory creates block challenge backends for challenges on the same chain,
+// which share caches of batch message counts and block results, as concurrent challenges often
+// reference overlapping batches. When any backend detects a reorg, both shared caches are cleared.
+type BlockChallengeBackendFactory struct {
+	config       *BlockChallengeBackendConfig
+	streamer     TransactionStreamerInterface
+	inboxTracker *cachingInboxTracker
+	resultCache  *syncLruCache[arbutil.MessageIndex, execution.MessageResult]
+}
+
+// NewBlockChallengeBackendFactory creates a factory whose backends use the given config, sharing a cache of
+// batchCacheSize batch message counts, and one of config.ResultCacheSize block results.
+func NewBlockChallengeBackendFactory(
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+	config *BlockChallengeBackendConfig,
+	batchCacheSize int,
+) *BlockChallengeBackendFactory {
+	if config == nil {
+		config = &DefaultBlockChallengeBackendConfig
+	}
+	return &BlockChallengeBackendFactory{
+		config:   config,
+		streamer: streamer,
+		inboxTracker: &cachingInboxTracker{
+			InboxTrackerInterface: inboxTracker,
+			cache:                 newSyncLruCache[uint64, arbutil.MessageIndex](batchCacheSize),
+		},
+		resultCache: newSyncLruCache[arbutil.MessageIndex, execution.MessageResult](config.ResultCacheSize),
+	}
+}
+
+// New creates a backend for the challenge initiated by the given event, as NewBlockChallengeBackendWithConfig does,
+// but reading through the factory's shared caches.
+func (f *BlockChallengeBackendFactory) New(
+	ctx context.Context,
+	initialState *challengegen.ChallengeManagerInitiatedChallenge,
+	maxBatchesRead uint64,
+) (*BlockChallengeBackend, error) {
+	backend, err := NewBlockChallengeBackendWithConfig(ctx, initialState, maxBatchesRead, f.streamer, f.inboxTracker, f.config)
+	if err != nil {
+		return nil, err
+	}
+	backend.resultCache = f.resultCache
+	backend.batchCache = f.inboxTracker.cache
+	return backend, nil
+}
+
+// cachingInboxTracker is an inbox tracker that caches the batch message counts it returns.
+type cachingInboxTracker struct {
+	InboxTrackerInterface
+	cache *syncLruCache[uint64, arbutil.MessageIndex]
+}
+
+func (t *cachingInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	if msgCount, ok := t.cache.Get(seqNum); ok {
+		return msgCount, nil
+	}
+	msgCount, err := t.InboxTrackerInterface.GetBatchMessageCount(seqNum)
+	if err != nil {
+		return 0, err
+	}
+	t.cache.Add(seqNum, msgCount)
+	return msgCount, nil
+}
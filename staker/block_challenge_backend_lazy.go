@@ -0,0 +1,112 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+// LazyBlockChallengeBackend is a block challenge backend whose contract reads and setup are deferred until
+// it's first used, for watching many challenges without paying to set up the ones that are never acted on.
+type LazyBlockChallengeBackend struct {
+	// mutex guards backend, and is held during initialization so concurrent first calls share one setup
+	mutex   sync.Mutex
+	init    func(ctx context.Context) (*BlockChallengeBackend, error)
+	backend *BlockChallengeBackend
+}
+
+// Assert that LazyBlockChallengeBackend implements ChallengeBackend
+var _ ChallengeBackend = (*LazyBlockChallengeBackend)(nil)
+
+// NewLazyBlockChallengeBackend creates a backend for the given challenge without making any calls.
+// On first use it reads the challenge's InitiatedChallenge event and info, as NewChallengeManager does,
+// and creates the backend from them with the given config.
+func NewLazyBlockChallengeBackend(
+	l1client bind.ContractBackend,
+	challengeManagerAddr common.Address,
+	challengeIndex uint64,
+	startL1Block uint64,
+	streamer TransactionStreamerInterface,
+	inboxTracker InboxTrackerInterface,
+	config *BlockChallengeBackendConfig,
+) *LazyBlockChallengeBackend {
+	return newLazyBlockChallengeBackend(func(ctx context.Context) (*BlockChallengeBackend, error) {
+		con, err := challengegen.NewChallengeManager(challengeManagerAddr, l1client)
+		if err != nil {
+			return nil, fmt.Errorf("error creating bindgen ChallengeManager: %w", err)
+		}
+		states, errs, err := FetchChallengeGlobalStates(ctx, l1client, challengeManagerAddr, startL1Block, []uint64{challengeIndex})
+		if err != nil {
+			return nil, err
+		}
+		if err := errs[challengeIndex]; err != nil {
+			return nil, err
+		}
+		startGs, endGs := states[challengeIndex][0], states[challengeIndex][1]
+		if startGs == (validator.GoGlobalState{}) {
+			return nil, fmt.Errorf("%w: start global state is zero", ErrChallengeNotInitialized)
+		}
+		challengeInfo, err := con.Challenges(&bind.CallOpts{Context: ctx}, new(big.Int).SetUint64(challengeIndex))
+		if err != nil {
+			return nil, fmt.Errorf("error getting challenge %v info: %w", challengeIndex, err)
+		}
+		backend, err := NewBlockChallengeBackendFromGlobalStates(ctx, startGs, endGs, challengeInfo.MaxInboxMessages, streamer, inboxTracker, config)
+		if err != nil {
+			return nil, fmt.Errorf("error creating block challenge backend for challenge %v: %w", challengeIndex, err)
+		}
+		return backend, nil
+	})
+}
+
+func newLazyBlockChallengeBackend(init func(ctx context.Context) (*BlockChallengeBackend, error)) *LazyBlockChallengeBackend {
+	return &LazyBlockChallengeBackend{init: init}
+}
+
+// Backend returns the underlying backend, initializing it if this is the first call.
+// If initialization fails, the error isn't cached and the next call tries again,
+// so a transient RPC error doesn't leave the backend permanently unusable.
+func (l *LazyBlockChallengeBackend) Backend(ctx context.Context) (*BlockChallengeBackend, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.backend != nil {
+		return l.backend, nil
+	}
+	backend, err := l.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.backend = backend
+	return backend, nil
+}
+
+// Initialized returns whether the underlying backend has been successfully initialized.
+func (l *LazyBlockChallengeBackend) Initialized() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.backend != nil
+}
+
+func (l *LazyBlockChallengeBackend) SetRange(ctx context.Context, start uint64, end uint64) error {
+	backend, err := l.Backend(ctx)
+	if err != nil {
+		return err
+	}
+	return backend.SetRange(ctx, start, end)
+}
+
+func (l *LazyBlockChallengeBackend) GetHashAtStep(ctx context.Context, position uint64) (common.Hash, error) {
+	backend, err := l.Backend(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return backend.GetHashAtStep(ctx, position)
+}
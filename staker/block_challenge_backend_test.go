@@ -0,0 +1,2758 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/execution"
+	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+// FakeBatchMetadataSource is an in-memory InboxTrackerInterface serving batch message counts.
+// Batches added with AppendBatch always have non-decreasing message counts; tests wanting corrupt
+// metadata can set batchMessageCounts directly.
+type FakeBatchMetadataSource struct {
+	batchMessageCounts []arbutil.MessageIndex
+	failures           map[uint64]error
+	delay              time.Duration
+	// The number of GetBatchMessageCount calls in progress, and the most there have been at once
+	activeReads atomic.Int32
+	peakReads   atomic.Int32
+	// How many times each batch's message count has been read
+	readsMutex sync.Mutex
+	reads      map[uint64]int
+}
+
+// NewFakeBatchMetadataSource creates a source with a batch for each of the given message counts.
+func NewFakeBatchMetadataSource(batchSizes ...uint64) *FakeBatchMetadataSource {
+	source := &FakeBatchMetadataSource{}
+	for _, size := range batchSizes {
+		source.AppendBatch(size)
+	}
+	return source
+}
+
+// AppendBatch adds a batch containing the given number of messages after the current last batch.
+func (t *FakeBatchMetadataSource) AppendBatch(messages uint64) {
+	var msgCount arbutil.MessageIndex
+	if len(t.batchMessageCounts) > 0 {
+		msgCount = t.batchMessageCounts[len(t.batchMessageCounts)-1]
+	}
+	t.batchMessageCounts = append(t.batchMessageCounts, msgCount+arbutil.MessageIndex(messages))
+}
+
+// FailBatch makes lookups of the given batch return err, or succeed again if err is nil.
+func (t *FakeBatchMetadataSource) FailBatch(seqNum uint64, err error) {
+	if t.failures == nil {
+		t.failures = make(map[uint64]error)
+	}
+	if err == nil {
+		delete(t.failures, seqNum)
+	} else {
+		t.failures[seqNum] = err
+	}
+}
+
+func (t *FakeBatchMetadataSource) SetBlockValidator(*BlockValidator) {}
+
+func (t *FakeBatchMetadataSource) GetDelayedMessageBytes(context.Context, uint64) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t *FakeBatchMetadataSource) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	active := t.activeReads.Add(1)
+	defer t.activeReads.Add(-1)
+	for {
+		peak := t.peakReads.Load()
+		if active <= peak || t.peakReads.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	t.readsMutex.Lock()
+	if t.reads == nil {
+		t.reads = make(map[uint64]int)
+	}
+	t.reads[seqNum]++
+	t.readsMutex.Unlock()
+	time.Sleep(t.delay)
+	if err := t.failures[seqNum]; err != nil {
+		return 0, err
+	}
+	if seqNum >= uint64(len(t.batchMessageCounts)) {
+		return 0, fmt.Errorf("batch %v not found", seqNum)
+	}
+	return t.batchMessageCounts[seqNum], nil
+}
+
+// ResetReads clears the counts of each batch's reads, returning the counts before they were cleared.
+func (t *FakeBatchMetadataSource) ResetReads() map[uint64]int {
+	t.readsMutex.Lock()
+	defer t.readsMutex.Unlock()
+	reads := t.reads
+	t.reads = nil
+	return reads
+}
+
+func (t *FakeBatchMetadataSource) GetBatchAcc(seqNum uint64) (common.Hash, error) {
+	return common.Hash{}, errors.New("not implemented")
+}
+
+func (t *FakeBatchMetadataSource) GetBatchCount() (uint64, error) {
+	return uint64(len(t.batchMessageCounts)), nil
+}
+
+func (t *FakeBatchMetadataSource) FindInboxBatchContainingMessage(pos arbutil.MessageIndex) (uint64, bool, error) {
+	return 0, false, errors.New("not implemented")
+}
+
+// testStreamer serves deterministic block results, counting how many it's served. Setting reorged
+// changes every block hash, as though the chain had reorged.
+type testStreamer struct {
+	lookups atomic.Uint64
+	reorged atomic.Bool
+}
+
+func testBlockHash(count arbutil.MessageIndex) common.Hash {
+	return crypto.Keccak256Hash([]byte("block"), binary.BigEndian.AppendUint64(nil, uint64(count)))
+}
+
+func testSendRoot(count arbutil.MessageIndex) common.Hash {
+	return crypto.Keccak256Hash([]byte("send root"), binary.BigEndian.AppendUint64(nil, uint64(count)))
+}
+
+func (s *testStreamer) SetBlockValidator(*BlockValidator) {}
+
+func (s *testStreamer) GetProcessedMessageCount() (arbutil.MessageIndex, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (s *testStreamer) GetMessage(arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *testStreamer) ResultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error) {
+	s.lookups.Add(1)
+	blockHash := testBlockHash(count)
+	if s.reorged.Load() {
+		blockHash = crypto.Keccak256Hash([]byte("reorged"), blockHash.Bytes())
+	}
+	return &execution.MessageResult{
+		BlockHash: blockHash,
+		SendRoot:  testSendRoot(count),
+	}, nil
+}
+
+func (s *testStreamer) PauseReorgs()                     {}
+func (s *testStreamer) ResumeReorgs()                    {}
+func (s *testStreamer) ChainConfig() *params.ChainConfig { return &params.ChainConfig{} }
+
+// testInboxTracker is a FakeBatchMetadataSource, or a wrapper of one, for a test backend to challenge the batches of.
+type testInboxTracker interface {
+	InboxTrackerInterface
+	batchSource() *FakeBatchMetadataSource
+}
+
+func (t *FakeBatchMetadataSource) batchSource() *FakeBatchMetadataSource {
+	return t
+}
+
+// newTestBlockChallengeBackend creates a backend challenging from the start of batch 1 to the end of the tracker's
+// last batch. It reads blocks from the streamer, or a testStreamer if it's nil, and uses the given config,
+// or the default if it's nil.
+func newTestBlockChallengeBackend(t testing.TB, tracker testInboxTracker, streamer TransactionStreamerInterface, config *BlockChallengeBackendConfig) *BlockChallengeBackend {
+	t.Helper()
+	backend, err := tryNewTestBlockChallengeBackend(tracker, streamer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// tryNewTestBlockChallengeBackend is newTestBlockChallengeBackend, but returns the error if setup fails.
+// The batch message counts are read from the fake directly, so wrappers only see the backend's own reads.
+func tryNewTestBlockChallengeBackend(tracker testInboxTracker, streamer TransactionStreamerInterface, config *BlockChallengeBackendConfig) (*BlockChallengeBackend, error) {
+	if streamer == nil {
+		streamer = &testStreamer{}
+	}
+	batchMessageCounts := tracker.batchSource().batchMessageCounts
+	batchCount := uint64(len(batchMessageCounts))
+	startMsgCount := batchMessageCounts[0]
+	return NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{BlockHash: testBlockHash(startMsgCount), SendRoot: testSendRoot(startMsgCount), Batch: 1},
+		validator.GoGlobalState{Batch: batchCount},
+		batchCount,
+		streamer,
+		tracker,
+		config,
+	)
+}
+
+func testCacheConfig(cacheSize int) *BlockChallengeBackendConfig {
+	config := DefaultBlockChallengeBackendConfig
+	config.ResultCacheSize = cacheSize
+	return &config
+}
+
+func TestGetHashesAtSteps(t *testing.T) {
+	ctx := context.Background()
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10, 15}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	positions := []uint64{13, 0, 5, 2, 14, 20, 7}
+	hashes, err := backend.GetHashesAtSteps(ctx, positions)
+	Require(t, err)
+	for i, position := range positions {
+		expected, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hashes[i] != expected {
+			Fail(t, "hash mismatch at position", position, "got", hashes[i], "expected", expected)
+		}
+	}
+}
+
+func TestGetHashesAtStepsConcurrency(t *testing.T) {
+	ctx := context.Background()
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10, 15, 21, 28, 36}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	var positions []uint64
+	for position := uint64(0); position < 35; position++ {
+		positions = append(positions, position)
+	}
+	// The delay keeps each read in progress long enough for the workers' reads to overlap
+	tracker.delay = time.Millisecond
+	tracker.peakReads.Store(0)
+	tracker.ResetReads()
+	hashes, err := backend.GetHashesAtSteps(ctx, positions)
+	Require(t, err)
+	if peak := tracker.peakReads.Load(); peak < 2 || peak > maxConcurrentHashLookups {
+		Fail(t, "expected between 2 and", maxConcurrentHashLookups, "concurrent batch metadata reads, got", peak)
+	}
+	// Every position's batch search and global state read metadata, but each batch's is only read once
+	reads := tracker.ResetReads()
+	for batch, count := range reads {
+		if count != 1 {
+			Fail(t, "expected batch", batch, "metadata to be read once, got", count, "reads")
+		}
+	}
+	if len(reads) > len(tracker.batchMessageCounts) {
+		Fail(t, "expected at most", len(tracker.batchMessageCounts), "batches to be read, got", len(reads))
+	}
+	for i, position := range positions {
+		expected, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hashes[i] != expected {
+			Fail(t, "hash at position", position, "doesn't match GetHashAtStep")
+		}
+	}
+}
+
+func TestGetHashesAtStepsCancelled(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := backend.GetHashesAtSteps(ctx, []uint64{0, 1, 2})
+	if !errors.Is(err, context.Canceled) {
+		Fail(t, "expected context cancellation error, got", err)
+	}
+}
+
+func TestFindFirstDivergence(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	tooFar := backend.tooFarStartsAtPosition
+	positions := []uint64{0, 3, 6, tooFar - 1, tooFar, tooFar + 2}
+	honest, err := backend.GetHashesAtSteps(ctx, positions)
+	Require(t, err)
+	wrong := common.HexToHash("0x1234")
+	tests := []struct {
+		name      string
+		wrong     []int
+		diverged  bool
+		divergent uint64
+	}{
+		{"no divergence", nil, false, 0},
+		{"first step", []int{0}, true, 0},
+		{"last finished step", []int{3}, true, tooFar - 1},
+		{"too far step", []int{4}, true, tooFar},
+		{"several steps", []int{5, 2, 4}, true, 6},
+	}
+	for _, test := range tests {
+		claimed := append([]common.Hash(nil), honest...)
+		for _, i := range test.wrong {
+			claimed[i] = wrong
+		}
+		divergent, diverged, err := backend.FindFirstDivergence(ctx, positions, claimed)
+		Require(t, err)
+		if diverged != test.diverged || divergent != test.divergent {
+			Fail(t, test.name, "expected divergence", test.diverged, "at", test.divergent, "got", diverged, "at", divergent)
+		}
+	}
+	// Positions needn't be in order
+	claimed := []common.Hash{honest[4], wrong, honest[0]}
+	divergent, diverged, err := backend.FindFirstDivergence(ctx, []uint64{tooFar, 3, 0}, claimed)
+	Require(t, err)
+	if !diverged || divergent != 3 {
+		Fail(t, "expected unordered positions to diverge at 3, got", diverged, "at", divergent)
+	}
+	if _, _, err := backend.FindFirstDivergence(ctx, positions, honest[1:]); err == nil {
+		Fail(t, "expected mismatched positions and claimed hashes to be rejected")
+	}
+}
+
+func BenchmarkGetHashesAtSteps(b *testing.B) {
+	ctx := context.Background()
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10, 15, 21, 28, 36}, delay: 50 * time.Microsecond}
+	backend := newTestBlockChallengeBackend(b, tracker, nil, nil)
+	var positions []uint64
+	for position := uint64(0); position < 35; position++ {
+		positions = append(positions, position)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.GetHashesAtSteps(ctx, positions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestIssueExecChallengeMismatchedSegments(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	state := &ChallengeState{
+		Start: big.NewInt(0),
+		End:   big.NewInt(2),
+		Segments: []ChallengeSegment{
+			{Hash: common.HexToHash("0x01"), Position: 0},
+			{Hash: common.HexToHash("0x02"), Position: 1},
+			{Hash: common.HexToHash("0x03"), Position: 2},
+		},
+		RawSegments: [][32]byte{common.HexToHash("0x01"), common.HexToHash("0x02")},
+	}
+	// The core is never used, as the segments are rejected before anything is submitted.
+	_, err := backend.IssueExecChallenge(&challengeCore{}, state, 0, 1)
+	if err == nil {
+		Fail(t, "expected mismatched segments to be rejected")
+	}
+}
+
+func TestExecChallengeArgsAt(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	segments := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")}
+	state := &ChallengeState{
+		Start:       big.NewInt(3),
+		End:         big.NewInt(5),
+		RawSegments: [][32]byte{segments[0], segments[1], segments[2]},
+	}
+	for i, hash := range segments {
+		state.Segments = append(state.Segments, ChallengeSegment{Hash: hash, Position: 3 + uint64(i)})
+	}
+	expected, err := backend.getExecChallengeArgs(state, 1)
+	Require(t, err)
+	args, err := backend.getExecChallengeArgsAt(state, 1, 4)
+	Require(t, err)
+	if args.globalStateHashes != expected.globalStateHashes || args.machineStatuses != expected.machineStatuses {
+		Fail(t, "exec challenge args at the segment's start don't match its default args")
+	}
+	for _, position := range []uint64{3, 5, 100} {
+		_, err := backend.getExecChallengeArgsAt(state, 1, position)
+		if err == nil || !strings.Contains(err.Error(), "isn't the step 4 proven") {
+			Fail(t, "expected position", position, "other than segment 1's start to be rejected, got", err)
+		}
+	}
+	// The core is never used, as the position is rejected before anything is submitted.
+	if _, err := backend.IssueExecChallengeAt(&challengeCore{}, state, 0, 1, 4); err == nil {
+		Fail(t, "expected a position other than the selected segment's start to be rejected")
+	}
+	// A segment of more than one step is still rejected at its start
+	state.Segments[2].Position, state.End = 6, big.NewInt(6)
+	if _, err := backend.getExecChallengeArgsAt(state, 1, 4); err == nil || !strings.Contains(err.Error(), "requires a single step") {
+		Fail(t, "expected a multi step segment to be rejected, got", err)
+	}
+}
+
+func TestBlockStateTooFarHash(t *testing.T) {
+	if blockStateTooFarHash != crypto.Keccak256Hash([]byte("Block state, too far:")) {
+		Fail(t, "precomputed too far hash", blockStateTooFarHash, "doesn't match a fresh computation")
+	}
+}
+
+func TestBlockStateHash(t *testing.T) {
+	gs := validator.GoGlobalState{BlockHash: testBlockHash(5), SendRoot: testSendRoot(5), Batch: 2, PosInBatch: 1}
+	hashes := make(map[common.Hash]uint8)
+	for _, status := range []uint8{StatusFinished, StatusErrored, StatusTooFar} {
+		hash, err := blockStateHash(gs, status)
+		Require(t, err)
+		if other, ok := hashes[hash]; ok {
+			Fail(t, "statuses", other, "and", status, "hash to the same block state")
+		}
+		hashes[hash] = status
+	}
+	errored, err := blockStateHash(gs, StatusErrored)
+	Require(t, err)
+	if errored != crypto.Keccak256Hash([]byte("Block state, errored:"), gs.Hash().Bytes()) {
+		Fail(t, "unexpected errored block state hash", errored)
+	}
+	if _, err := blockStateHash(gs, 0); err == nil {
+		Fail(t, "expected an unknown block status to error")
+	}
+}
+
+func TestGlobalStateForBlock(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	// With a genesis block of 0, the global state after block n is the one after n+1 messages.
+	for blockNum := uint64(0); blockNum < 9; blockNum++ {
+		gs, err := GlobalStateForBlock(&testStreamer{}, tracker, blockNum)
+		Require(t, err)
+		expected, err := backend.FindGlobalStateFromMessageCount(arbutil.MessageIndex(blockNum + 1))
+		Require(t, err)
+		if gs != expected {
+			Fail(t, "block", blockNum, "got global state", gs, "expected", expected)
+		}
+	}
+	_, err := GlobalStateForBlock(&testStreamer{}, tracker, 20)
+	if err == nil {
+		Fail(t, "expected block past the last batch to fail")
+	}
+}
+
+func TestStrictBatchOrdering(t *testing.T) {
+	// Batch 2's message count is corrupt, which a lax search silently misses.
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 20, 6, 15}}
+	lax := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	batch, err := lax.findBatchAfterMessageCount(5)
+	Require(t, err)
+	if batch != 2 {
+		Fail(t, "expected lax search to return batch 2 but got", batch)
+	}
+
+	config := DefaultBlockChallengeBackendConfig
+	config.StrictBatchOrdering = true
+	strict := newTestBlockChallengeBackend(t, tracker, nil, &config)
+	_, err = strict.findBatchAfterMessageCount(5)
+	if !errors.Is(err, ErrBatchMessageCountsNotMonotonic) {
+		Fail(t, "expected strict search to detect non-monotonic batches, got", err)
+	}
+	_, err = strict.findBatchAfterMessageCount(12)
+	Require(t, err)
+}
+
+func TestBlockChallengeBackendNotInitialized(t *testing.T) {
+	tracker := NewFakeBatchMetadataSource(1, 2, 3)
+	initialState := &challengegen.ChallengeManagerInitiatedChallenge{}
+	_, err := NewBlockChallengeBackend(context.Background(), initialState, 3, &testStreamer{}, tracker)
+	if !errors.Is(err, ErrChallengeNotInitialized) {
+		Fail(t, "expected a zero start global state to be reported as an uninitialized challenge, got", err)
+	}
+}
+
+func TestBlockChallengeBackendRejectsEndBeforeStart(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10}}
+	_, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{Batch: 3},
+		validator.GoGlobalState{Batch: 2},
+		2,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected end batch before start batch to be rejected")
+	}
+	_, err = NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{Batch: 3, PosInBatch: 1},
+		validator.GoGlobalState{Batch: 3},
+		3,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected end message count before start message count to be rejected")
+	}
+	// The end batch is before the start batch, though the batches read still end after the start
+	_, err = NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{Batch: 3},
+		validator.GoGlobalState{Batch: 2},
+		4,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected end batch before start batch to be rejected despite the batches read")
+	}
+}
+
+func TestExecChallengeCalldata(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	state := &ChallengeState{
+		Start: big.NewInt(3),
+		End:   big.NewInt(4),
+		Segments: []ChallengeSegment{
+			{Hash: common.HexToHash("0x01"), Position: 3},
+			{Hash: common.HexToHash("0x02"), Position: 4},
+		},
+		RawSegments: [][32]byte{common.HexToHash("0x01"), common.HexToHash("0x02")},
+	}
+	data, err := backend.ExecChallengeCalldata(7, state, 0, 100)
+	Require(t, err)
+
+	parsedABI, err := challengegen.ChallengeManagerMetaData.GetAbi()
+	Require(t, err)
+	method, err := parsedABI.MethodById(data[:4])
+	Require(t, err)
+	if method.Name != "challengeExecution" {
+		Fail(t, "calldata is for method", method.Name)
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	Require(t, err)
+	startInfo, err := backend.GetInfoAtStep(3)
+	Require(t, err)
+	startGs := startInfo.GlobalState
+	endInfo, err := backend.GetInfoAtStep(4)
+	Require(t, err)
+	endGs := endInfo.GlobalState
+	if args[0].(uint64) != 7 {
+		Fail(t, "unexpected challenge index", args[0])
+	}
+	if args[2].([2]uint8) != [2]uint8{StatusFinished, StatusFinished} {
+		Fail(t, "unexpected machine statuses", args[2])
+	}
+	if args[3].([2][32]byte) != [2][32]byte{startGs.Hash(), endGs.Hash()} {
+		Fail(t, "unexpected global state hashes", args[3])
+	}
+	if args[4].(*big.Int).Uint64() != 100 {
+		Fail(t, "unexpected step count", args[4])
+	}
+}
+
+func TestSetRangeKeepsTooFarBoundary(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(1, 2, 3, 4)
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	tooFar := backend.tooFarStartsAtPosition
+	Require(t, backend.SetRange(ctx, 0, 20))
+	Require(t, backend.SetRange(ctx, 2, 6))
+	if backend.startPosition != 2 || backend.endPosition != 6 {
+		Fail(t, "range is", backend.startPosition, "to", backend.endPosition, "after setting it to 2 to 6")
+	}
+	if backend.tooFarStartsAtPosition != tooFar {
+		Fail(t, "too far boundary moved from", tooFar, "to", backend.tooFarStartsAtPosition)
+	}
+	if !backend.IsTooFar(tooFar) || backend.IsTooFar(tooFar-1) {
+		Fail(t, "too far boundary isn't at position", tooFar)
+	}
+}
+
+// funcInboxTracker computes batch message counts with a function, allowing sparse or huge batch indices.
+type funcInboxTracker struct {
+	FakeBatchMetadataSource
+	batchMessageCount func(seqNum uint64) arbutil.MessageIndex
+}
+
+func (t *funcInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	return t.batchMessageCount(seqNum), nil
+}
+
+func TestSearchBatchNearMaxUint64(t *testing.T) {
+	base := uint64(math.MaxUint64 - 100)
+	tracker := &funcInboxTracker{
+		batchMessageCount: func(seqNum uint64) arbutil.MessageIndex {
+			return arbutil.MessageIndex(2 * (seqNum - base))
+		},
+	}
+	expected := uint64(math.MaxUint64 - 5)
+	msgCount := arbutil.MessageIndex(2*(expected-base) - 1)
+	for _, strict := range []bool{false, true} {
+		batch, err := searchBatchAfterMessageCount(tracker, msgCount, math.MaxUint64-10, math.MaxUint64-1, strict)
+		Require(t, err)
+		if batch != expected {
+			Fail(t, "expected batch", expected, "but got", batch)
+		}
+	}
+}
+
+func TestSegmentPositions(t *testing.T) {
+	positions := segmentPositions(5, 15, 3)
+	expected := []uint64{5, 8, 11, 15}
+	if len(positions) != len(expected) {
+		Fail(t, "got positions", positions, "expected", expected)
+	}
+	for i := range expected {
+		if positions[i] != expected[i] {
+			Fail(t, "got positions", positions, "expected", expected)
+		}
+	}
+}
+
+func TestBisectionGlobalStateHashes(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	// The contract spaces 9 steps over 4 segments 2 apart, with the remainder in the last segment
+	hashes, err := backend.BisectionGlobalStateHashes(ctx, 0, 9, 4)
+	Require(t, err)
+	expectedPositions := []uint64{0, 2, 4, 6, 9}
+	if len(hashes) != len(expectedPositions) {
+		Fail(t, "expected", len(expectedPositions), "hashes, got", len(hashes))
+	}
+	for i, position := range expectedPositions {
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		if hashes[i] != info.GlobalState.Hash() {
+			Fail(t, "hash", i, "doesn't match the global state at position", position)
+		}
+	}
+	if _, err := backend.BisectionGlobalStateHashes(ctx, 0, backend.tooFarStartsAtPosition, 4); err == nil {
+		Fail(t, "expected an error for a bisection ending at the too far boundary")
+	}
+	if _, err := backend.BisectionGlobalStateHashes(ctx, 0, 3, 4); err == nil {
+		Fail(t, "expected an error for more segments than steps")
+	}
+}
+
+func TestSegmentsHash(t *testing.T) {
+	ctx := context.Background()
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{1, 3, 6, 10}}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	hashes, root, err := backend.SegmentsHash(ctx, 0, 10, 3)
+	Require(t, err)
+	expected, err := backend.GetHashesAtSteps(ctx, []uint64{0, 3, 6, 10})
+	Require(t, err)
+	for i := range expected {
+		if hashes[i] != expected[i] {
+			Fail(t, "segment", i, "hash", hashes[i], "expected", expected[i])
+		}
+	}
+	var data []byte
+	data = append(data, common.BigToHash(big.NewInt(0)).Bytes()...)
+	data = append(data, common.BigToHash(big.NewInt(10)).Bytes()...)
+	for _, hash := range expected {
+		data = append(data, hash.Bytes()...)
+	}
+	if root != crypto.Keccak256Hash(data) {
+		Fail(t, "unexpected challenge state hash", root)
+	}
+	_, _, err = backend.SegmentsHash(ctx, 0, 2, 3)
+	if err == nil {
+		Fail(t, "expected more segments than steps to be rejected")
+	}
+}
+
+func TestFakeBatchMetadataSource(t *testing.T) {
+	source := NewFakeBatchMetadataSource(1, 2)
+	source.AppendBatch(3)
+	for seqNum, expected := range []arbutil.MessageIndex{1, 3, 6} {
+		msgCount, err := source.GetBatchMessageCount(uint64(seqNum))
+		Require(t, err)
+		if msgCount != expected {
+			Fail(t, "batch", seqNum, "has message count", msgCount, "expected", expected)
+		}
+	}
+	injected := errors.New("injected failure")
+	source.FailBatch(1, injected)
+	if _, err := source.GetBatchMessageCount(1); !errors.Is(err, injected) {
+		Fail(t, "expected injected failure, got", err)
+	}
+	source.FailBatch(1, nil)
+	_, err := source.GetBatchMessageCount(1)
+	Require(t, err)
+}
+
+func TestSearchBatchTerminatesOnCorruptMetadata(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var lookups int
+		tracker := &funcInboxTracker{
+			batchMessageCount: func(uint64) arbutil.MessageIndex {
+				lookups++
+				return arbutil.MessageIndex(rand.Uint64() % 1000)
+			},
+		}
+		low := rand.Uint64() % 1000
+		high := low + rand.Uint64()%1000
+		// Corrupt metadata may produce an error or a wrong batch, but the search must end either way
+		_, _ = searchBatchAfterMessageCount(tracker, arbutil.MessageIndex(1+rand.Uint64()%1000), low, high, false)
+		if lookups > 64+bits.Len64(high-low) {
+			Fail(t, "search of batches", low, "to", high, "took", lookups, "lookups")
+		}
+	}
+}
+
+func TestIsSingleStep(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(1, 2, 3, 4), nil, nil)
+	if backend.IsSingleStep() {
+		Fail(t, "newly created backend is a single step")
+	}
+	backend.startPosition = math.MaxUint64 - 1
+	if backend.IsSingleStep() {
+		Fail(t, "backend with an unbounded end is a single step")
+	}
+	backend.startPosition = 0
+	Require(t, backend.SetRange(ctx, 0, 4))
+	if backend.IsSingleStep() {
+		Fail(t, "range of 4 steps is a single step")
+	}
+	Require(t, backend.SetRange(ctx, 2, 3))
+	if !backend.IsSingleStep() {
+		Fail(t, "range of 1 step isn't a single step")
+	}
+}
+
+func TestSetRangeWithStates(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultBlockChallengeBackendConfig
+	config.VerifySuppliedStates = true
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(1, 2, 3, 4), nil, &config)
+	startInfo, err := backend.GetInfoAtStep(2)
+	Require(t, err)
+	startGs := startInfo.GlobalState
+	endInfo, err := backend.GetInfoAtStep(5)
+	Require(t, err)
+	endGs := endInfo.GlobalState
+	if err := backend.SetRangeWithStates(ctx, 2, 5, startGs, validator.GoGlobalState{}); err == nil {
+		Fail(t, "expected wrong supplied end state to be rejected")
+	}
+	Require(t, backend.SetRangeWithStates(ctx, 2, 5, startGs, endGs))
+	if backend.startGs != startGs || backend.endGs != endGs {
+		Fail(t, "range states weren't updated")
+	}
+}
+
+func TestBlockChallengeDiagnostics(t *testing.T) {
+	tracker := NewFakeBatchMetadataSource(1, 2, 3, 4)
+	tracker.FailBatch(3, errors.New("not yet ingested"))
+	_, diagnostics, err := NewBlockChallengeBackendWithDiagnostics(
+		context.Background(),
+		validator.GoGlobalState{Batch: 2, PosInBatch: 1},
+		validator.GoGlobalState{Batch: 4},
+		4,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected missing end batch to fail setup")
+	}
+	if !diagnostics.HaveStartMsgCount || diagnostics.StartMsgCount != 4 {
+		Fail(t, "expected start message count 4 in diagnostics", diagnostics)
+	}
+	if diagnostics.HaveEndMsgCount {
+		Fail(t, "unexpected end message count in diagnostics", diagnostics)
+	}
+}
+
+// bisectTestBackend bisects the backend's whole range down to a single step, as a challenge would,
+// always picking the segment that follows the middle segment point.
+func bisectTestBackend(t *testing.T, backend *BlockChallengeBackend, degree uint64) {
+	t.Helper()
+	ctx := context.Background()
+	start, end := uint64(0), backend.tooFarStartsAtPosition
+	for end-start > 1 {
+		positions := segmentPositions(start, end, min(degree, end-start))
+		_, err := backend.GetHashesAtSteps(ctx, positions)
+		Require(t, err)
+		mid := len(positions) / 2
+		start, end = positions[mid-1], positions[mid]
+		Require(t, backend.SetRange(ctx, start, end))
+	}
+}
+
+func TestResultCacheReducesLookups(t *testing.T) {
+	batchSizes := make([]uint64, 64)
+	for i := range batchSizes {
+		batchSizes[i] = uint64(i%7 + 1)
+	}
+
+	uncachedStreamer := &testStreamer{}
+	bisectTestBackend(t, newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(batchSizes...), uncachedStreamer, testCacheConfig(0)), 5)
+	cachedStreamer := &testStreamer{}
+	bisectTestBackend(t, newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(batchSizes...), cachedStreamer, testCacheConfig(64)), 5)
+
+	uncached, cached := uncachedStreamer.lookups.Load(), cachedStreamer.lookups.Load()
+	t.Logf("block result lookups over bisection: %v uncached, %v cached", uncached, cached)
+	if cached >= uncached {
+		Fail(t, "cache didn't reduce block result lookups:", cached, "cached vs", uncached, "uncached")
+	}
+}
+
+func TestResultCacheDetectsReorg(t *testing.T) {
+	ctx := context.Background()
+	streamer := &testStreamer{}
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), streamer, testCacheConfig(16))
+	Require(t, backend.SetRange(ctx, 0, 8))
+	streamer.reorged.Store(true)
+	if err := backend.SetRange(ctx, 0, 4); err == nil {
+		Fail(t, "expected SetRange to detect the reorg despite the start's block result being cached")
+	}
+}
+
+func TestBisectionTree(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	levels, err := backend.BisectionTree(ctx, 3, 100)
+	Require(t, err)
+	if len(levels) == 0 || len(levels[0].Ranges) != 1 {
+		Fail(t, "expected a single root range, got", levels)
+	}
+	root := levels[0].Ranges[0]
+	if root.Start != 0 || root.End != backend.tooFarStartsAtPosition {
+		Fail(t, "unexpected root range", root.Start, "to", root.End)
+	}
+	singleSteps := 0
+	for depth, level := range levels {
+		if level.Depth != uint64(depth) {
+			Fail(t, "level", depth, "has depth", level.Depth)
+		}
+		for _, r := range level.Ranges {
+			expectedPositions := segmentPositions(r.Start, r.End, min(3, r.End-r.Start))
+			if fmt.Sprint(r.Positions) != fmt.Sprint(expectedPositions) {
+				Fail(t, "range", r.Start, "to", r.End, "has positions", r.Positions, "expected", expectedPositions)
+			}
+			for i, position := range r.Positions {
+				expected, err := backend.GetHashAtStep(ctx, position)
+				Require(t, err)
+				if r.Hashes[i] != expected {
+					Fail(t, "hash mismatch at position", position)
+				}
+				if i > 0 && position-r.Positions[i-1] == 1 {
+					singleSteps++
+				}
+			}
+		}
+	}
+	// With an unbounded depth, the tree must bisect down to every single step
+	if singleSteps != int(root.End-root.Start) {
+		Fail(t, "expected", root.End-root.Start, "single step segments, got", singleSteps)
+	}
+
+	shallow, err := backend.BisectionTree(ctx, 3, 1)
+	Require(t, err)
+	if len(shallow) != 1 {
+		Fail(t, "expected one level with a max depth of 1, got", len(shallow))
+	}
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := backend.BisectionTree(cancelledCtx, 3, 100); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled bisection tree to return context.Canceled, got", err)
+	}
+}
+
+func TestVerifyInitialGlobalStates(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	startGs, endGs := backend.InitialGlobalStates()
+	Require(t, backend.verifyInitialGlobalStates(startGs, endGs))
+
+	changedStart := startGs
+	changedStart.PosInBatch++
+	if err := backend.verifyInitialGlobalStates(changedStart, endGs); !errors.Is(err, ErrChallengeGlobalStatesChanged) {
+		Fail(t, "expected a changed start global state to be detected, got", err)
+	}
+	changedEnd := endGs
+	changedEnd.BlockHash = common.HexToHash("0x1234")
+	if err := backend.verifyInitialGlobalStates(startGs, changedEnd); !errors.Is(err, ErrChallengeGlobalStatesChanged) {
+		Fail(t, "expected a changed end global state to be detected, got", err)
+	}
+}
+
+func TestVerifyAgainstContract(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	startGs, endGs := backend.InitialGlobalStates()
+	challengeManagerAddr := common.HexToAddress("0x1234")
+	l1client := &fakeChallengeManagerBackend{logs: []types.Log{initiatedChallengeLog(t, challengeManagerAddr, 10, 1, startGs, endGs)}}
+	Require(t, backend.VerifyAgainstContract(ctx, l1client, challengeManagerAddr, 0, 1))
+
+	if err := backend.VerifyAgainstContract(ctx, l1client, challengeManagerAddr, 0, 2); err == nil {
+		Fail(t, "expected a challenge without an InitiatedChallenge event to fail verification")
+	}
+
+	changedEnd := endGs
+	changedEnd.PosInBatch++
+	l1client.logs = append(l1client.logs, initiatedChallengeLog(t, challengeManagerAddr, 11, 1, startGs, changedEnd))
+	if err := backend.VerifyAgainstContract(ctx, l1client, challengeManagerAddr, 0, 1); !errors.Is(err, ErrChallengeGlobalStatesChanged) {
+		Fail(t, "expected the contract's changed end global state to be detected, got", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(3, 4, 5)
+	streamer := &testStreamer{}
+	backend := newTestBlockChallengeBackend(t, tracker, streamer, testCacheConfig(0))
+	Require(t, backend.HealthCheck(ctx))
+	if lookups := streamer.lookups.Load(); lookups != 1 {
+		Fail(t, "expected a health check to do a single block result lookup, got", lookups)
+	}
+
+	streamer.reorged.Store(true)
+	if err := backend.HealthCheck(ctx); err == nil {
+		Fail(t, "expected a health check to fail after the start block reorged")
+	}
+	streamer.reorged.Store(false)
+
+	backend.streamer = &nilResultStreamer{}
+	if err := backend.HealthCheck(ctx); err == nil || !strings.Contains(err.Error(), "no block result") {
+		Fail(t, "expected a health check to fail when the streamer returns no result, got", err)
+	}
+	backend.streamer = streamer
+
+	pruned := errors.New("batch pruned")
+	tracker.FailBatch(0, pruned)
+	if err := backend.HealthCheck(ctx); !errors.Is(err, pruned) {
+		Fail(t, "expected a health check to fail with the batch error, got", err)
+	}
+}
+
+// nilResultStreamer is a testStreamer that returns no block results and no error.
+type nilResultStreamer struct {
+	testStreamer
+}
+
+func (s *nilResultStreamer) ResultAtCount(arbutil.MessageIndex) (*execution.MessageResult, error) {
+	return nil, nil
+}
+
+// prunedStreamer is a testStreamer that's pruned the results of blocks before prunedBelow.
+type prunedStreamer struct {
+	testStreamer
+	prunedBelow arbutil.MessageIndex
+}
+
+func (s *prunedStreamer) ResultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error) {
+	if count < s.prunedBelow {
+		return nil, fmt.Errorf("block result at message count %v pruned", count)
+	}
+	return s.testStreamer.ResultAtCount(count)
+}
+
+func TestFallbackResultSource(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(3, 4, 5, 6)
+	newBackend := func(fallback BlockResultSource) *BlockChallengeBackend {
+		config := DefaultBlockChallengeBackendConfig
+		config.FallbackResultSource = fallback
+		return newTestBlockChallengeBackend(t, tracker, &prunedStreamer{prunedBelow: 10}, &config)
+	}
+	reference := newTestBlockChallengeBackend(t, tracker, nil, nil)
+
+	if _, err := newBackend(nil).GetHashAtStep(ctx, 2); err == nil {
+		Fail(t, "expected a pruned block result to error without a fallback source")
+	}
+
+	fallback := &testStreamer{}
+	backend := newBackend(fallback)
+	for _, position := range []uint64{2, 12} {
+		hash, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		expected, err := reference.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "hash mismatch at position", position)
+		}
+	}
+	// Only the pruned position needed the fallback source
+	if lookups := fallback.lookups.Load(); lookups != 1 {
+		Fail(t, "expected one fallback lookup, got", lookups)
+	}
+}
+
+func TestGetHashRange(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 1, 4, 1, 5, 9, 2), nil, nil)
+	end := backend.tooFarStartsAtPosition + 3
+	for _, start := range []uint64{0, 1, 4, backend.tooFarStartsAtPosition - 1, end} {
+		hashes, err := backend.GetHashRange(ctx, start, end)
+		Require(t, err)
+		if len(hashes) != int(end-start) {
+			Fail(t, "expected", end-start, "hashes from step", start, "got", len(hashes))
+		}
+		for i, hash := range hashes {
+			position := start + uint64(i)
+			expected, err := backend.GetHashAtStep(ctx, position)
+			Require(t, err)
+			if hash != expected {
+				Fail(t, "hash mismatch at position", position, "from start", start)
+			}
+		}
+	}
+}
+
+// newMidBatchEndTestBackend creates a backend whose assertion ends part way through batch 2, but read all its
+// messages, so the last position is after message count 12, at the start of batch 3, which hasn't been posted.
+// newTestBlockChallengeBackend's challenges always end on a batch boundary instead.
+func newMidBatchEndTestBackend(t *testing.T, streamer TransactionStreamerInterface) *BlockChallengeBackend {
+	t.Helper()
+	backend, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{BlockHash: testBlockHash(3), SendRoot: testSendRoot(3), Batch: 1},
+		validator.GoGlobalState{Batch: 2, PosInBatch: 2},
+		3,
+		streamer,
+		NewFakeBatchMetadataSource(3, 4, 5),
+		&DefaultBlockChallengeBackendConfig,
+	)
+	Require(t, err)
+	return backend
+}
+
+func TestWalkMidBatchEnd(t *testing.T) {
+	ctx := context.Background()
+	backend := newMidBatchEndTestBackend(t, &testStreamer{})
+	end := backend.tooFarStartsAtPosition + 1
+	hashes, err := backend.GetHashRange(ctx, 0, end)
+	Require(t, err)
+	var replayed []validator.GoGlobalState
+	Require(t, backend.Replay(ctx, func(_ uint64, gs validator.GoGlobalState, _ uint8) error {
+		replayed = append(replayed, gs)
+		return nil
+	}))
+	for position := uint64(0); position < end; position++ {
+		expected, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hashes[position] != expected {
+			Fail(t, "hash range mismatch at position", position)
+		}
+		if position >= uint64(len(replayed)) {
+			continue
+		}
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		if replayed[position] != info.GlobalState {
+			Fail(t, "replayed global state", replayed[position], "at position", position, "doesn't match", info.GlobalState)
+		}
+	}
+	if last := replayed[len(replayed)-1]; last.Batch != 3 || last.PosInBatch != 0 {
+		Fail(t, "expected the last position to be at the start of batch 3, got", last)
+	}
+
+	// Walking past the end of a narrowed range still advances through the batches
+	reference := newMidBatchEndTestBackend(t, &testStreamer{})
+	Require(t, backend.SetRange(ctx, 1, 3))
+	hashes, err = backend.GetHashRange(ctx, 1, end)
+	Require(t, err)
+	for i, hash := range hashes {
+		position := 1 + uint64(i)
+		expected, err := reference.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "hash range mismatch at position", position, "after narrowing the range")
+		}
+	}
+}
+
+func benchmarkHashRangeTracker() *FakeBatchMetadataSource {
+	batchSizes := make([]uint64, 64)
+	for i := range batchSizes {
+		batchSizes[i] = 16
+	}
+	tracker := NewFakeBatchMetadataSource(batchSizes...)
+	tracker.delay = time.Microsecond
+	return tracker
+}
+
+func BenchmarkGetHashRange(b *testing.B) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(b, benchmarkHashRangeTracker(), nil, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.GetHashRange(ctx, 0, backend.tooFarStartsAtPosition); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetHashRangePerStep(b *testing.B) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(b, benchmarkHashRangeTracker(), nil, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for position := uint64(0); position < backend.tooFarStartsAtPosition; position++ {
+			if _, err := backend.GetHashAtStep(ctx, position); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestBatchAtStep(t *testing.T) {
+	ctx := context.Background()
+	streamer := &testStreamer{}
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), streamer, testCacheConfig(0))
+	for position := uint64(0); position < backend.tooFarStartsAtPosition; position++ {
+		batch, tooFar, err := backend.BatchAtStep(ctx, position)
+		Require(t, err)
+		if tooFar {
+			Fail(t, "position", position, "unexpectedly too far")
+		}
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		if batch != info.Batch {
+			Fail(t, "position", position, "is in batch", info.Batch, "but got", batch)
+		}
+	}
+	lookups := streamer.lookups.Load()
+	if _, tooFar, err := backend.BatchAtStep(ctx, backend.tooFarStartsAtPosition); err != nil || !tooFar {
+		Fail(t, "expected the too far boundary to be too far, got", tooFar, err)
+	}
+	if _, _, err := backend.BatchAtStep(ctx, 3); err != nil {
+		Fail(t, err)
+	}
+	if streamer.lookups.Load() != lookups {
+		Fail(t, "BatchAtStep looked up a block result")
+	}
+}
+
+func TestProgressFraction(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	if progress := backend.ProgressFraction(); progress != 0 {
+		Fail(t, "expected no progress before SetRange, got", progress)
+	}
+	total := backend.tooFarStartsAtPosition
+	Require(t, backend.SetRange(ctx, 0, total))
+	if progress := backend.ProgressFraction(); progress != 0 {
+		Fail(t, "expected no progress over the whole range, got", progress)
+	}
+	Require(t, backend.SetRange(ctx, total/2, total+100))
+	if progress := backend.ProgressFraction(); progress != 0 {
+		Fail(t, "expected progress to be clamped to 0, got", progress)
+	}
+	Require(t, backend.SetRange(ctx, total/2, total))
+	if progress := backend.ProgressFraction(); progress <= 0 || progress >= 1 {
+		Fail(t, "expected partial progress over half the range, got", progress)
+	}
+	Require(t, backend.SetRange(ctx, total/2+1, total/2+2))
+	if progress := backend.ProgressFraction(); progress <= 0.9 || progress > 1 {
+		Fail(t, "expected nearly complete progress at a single step, got", progress)
+	}
+}
+
+func TestGetInfoAtStepErrorContext(t *testing.T) {
+	tracker := NewFakeBatchMetadataSource(3, 4, 5, 6)
+	backend := newTestBlockChallengeBackend(t, tracker, &prunedStreamer{prunedBelow: 10}, nil)
+	// Step 2 is message count 5, in batch 1, whose block result has been pruned
+	_, err := backend.GetInfoAtStep(2)
+	if err == nil {
+		Fail(t, "expected a pruned block result to error")
+	}
+	for _, context := range []string{"step 2", "message count 5", "batch 1"} {
+		if !strings.Contains(err.Error(), context) {
+			Fail(t, "error", err, "is missing context", context)
+		}
+	}
+
+	injected := errors.New("injected failure")
+	tracker.FailBatch(2, injected)
+	_, err = backend.GetInfoAtStep(12)
+	if !errors.Is(err, injected) {
+		Fail(t, "expected the batch metadata error, got", err)
+	}
+	for _, context := range []string{"step 12", "message count 15"} {
+		if !strings.Contains(err.Error(), context) {
+			Fail(t, "error", err, "is missing context", context)
+		}
+	}
+}
+
+func TestBlockChallengeBackendRejectsOverflows(t *testing.T) {
+	tracker := &FakeBatchMetadataSource{batchMessageCounts: []arbutil.MessageIndex{3, math.MaxUint64}}
+	_, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{Batch: 1, PosInBatch: math.MaxUint64 - 1},
+		validator.GoGlobalState{Batch: 2},
+		2,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected a start position in batch overflowing the message count to be rejected")
+	}
+	_, err = NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{},
+		validator.GoGlobalState{Batch: 2},
+		2,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	if err == nil {
+		Fail(t, "expected a challenge too long for its too far boundary to be rejected")
+	}
+}
+
+func TestGlobalStateInBatchRejectsBadBatch(t *testing.T) {
+	tracker := NewFakeBatchMetadataSource(3, 4, 5)
+	// Message count 2 is in batch 0, so batch 2's previous batch ends after it
+	_, err := globalStateInBatch((&testStreamer{}).ResultAtCount, tracker, 2, 2)
+	if err == nil {
+		Fail(t, "expected a batch starting after the message count to be rejected")
+	}
+}
+
+// fakeClock advances instantly whenever it's waited on, recording how long each wait was for.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// flakyInboxTracker fails reads of each batch in failuresLeft until its count of failures reaches zero.
+type flakyInboxTracker struct {
+	*FakeBatchMetadataSource
+	mutex        sync.Mutex
+	failuresLeft map[uint64]int
+}
+
+func (t *flakyInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	t.mutex.Lock()
+	failing := t.failuresLeft[seqNum] > 0
+	if failing {
+		t.failuresLeft[seqNum]--
+	}
+	t.mutex.Unlock()
+	if failing {
+		return 0, errors.New("transient failure")
+	}
+	return t.FakeBatchMetadataSource.GetBatchMessageCount(seqNum)
+}
+
+func TestWaitForBatchesBackoff(t *testing.T) {
+	tracker := &flakyInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5), failuresLeft: map[uint64]int{2: 3}}
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	config := DefaultBlockChallengeBackendConfig
+	config.WaitForBatches = true
+	config.Clock = clock
+	newTestBlockChallengeBackend(t, tracker, nil, &config)
+	expected := []time.Duration{batchWaitInitialBackoff, 2 * batchWaitInitialBackoff, 4 * batchWaitInitialBackoff}
+	if fmt.Sprint(clock.waits) != fmt.Sprint(expected) {
+		Fail(t, "expected backoffs", expected, "got", clock.waits)
+	}
+}
+
+func TestSetupRetries(t *testing.T) {
+	newBackend := func(endBatchFailures int, retries int, clock Clock) error {
+		tracker := &flakyInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5), failuresLeft: map[uint64]int{2: endBatchFailures}}
+		config := DefaultBlockChallengeBackendConfig
+		config.SetupRetries = retries
+		config.SetupRetryBackoff = time.Second
+		config.Clock = clock
+		_, err := tryNewTestBlockChallengeBackend(tracker, nil, &config)
+		return err
+	}
+
+	if err := newBackend(1, 0, &fakeClock{}); err == nil {
+		Fail(t, "expected a transient failure to abort setup without retries")
+	}
+
+	clock := &fakeClock{}
+	Require(t, newBackend(2, 2, clock))
+	if fmt.Sprint(clock.waits) != fmt.Sprint([]time.Duration{time.Second, 2 * time.Second}) {
+		Fail(t, "unexpected retry backoffs", clock.waits)
+	}
+
+	err := newBackend(10, 2, &fakeClock{})
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		Fail(t, "expected exhausted retries to report the attempt count, got", err)
+	}
+}
+
+// rendezvousInboxTracker blocks its first two batch message count reads until both have started.
+type rendezvousInboxTracker struct {
+	*FakeBatchMetadataSource
+	reads    atomic.Int32
+	together chan struct{}
+}
+
+func (t *rendezvousInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	switch t.reads.Add(1) {
+	case 1:
+		select {
+		case <-t.together:
+		case <-time.After(10 * time.Second):
+			return 0, errors.New("second read never started")
+		}
+	case 2:
+		close(t.together)
+	}
+	return t.FakeBatchMetadataSource.GetBatchMessageCount(seqNum)
+}
+
+func TestSetupReadsConcurrently(t *testing.T) {
+	tracker := &rendezvousInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5), together: make(chan struct{})}
+	newTestBlockChallengeBackend(t, tracker, nil, nil)
+}
+
+func TestPositionsForBatch(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	positionsByBatch := make(map[uint64][]uint64)
+	for position := uint64(0); position < backend.tooFarStartsAtPosition; position++ {
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		positionsByBatch[info.Batch] = append(positionsByBatch[info.Batch], position)
+	}
+	for batch := uint64(0); batch <= 4; batch++ {
+		first, last, err := backend.PositionsForBatch(ctx, batch)
+		positions, ok := positionsByBatch[batch]
+		if !ok {
+			if !errors.Is(err, ErrBatchOutsideChallenge) {
+				Fail(t, "expected batch", batch, "to be outside the challenge, got", first, last, err)
+			}
+			continue
+		}
+		Require(t, err)
+		if first != positions[0] || last != positions[len(positions)-1] {
+			Fail(t, "batch", batch, "has positions", positions, "but got", first, "to", last)
+		}
+	}
+	if _, _, err := backend.PositionsForBatch(ctx, 5); !errors.Is(err, ErrBatchOutsideChallenge) {
+		Fail(t, "expected a batch after the challenge to be outside it, got", err)
+	}
+}
+
+func TestFallbackBatchSource(t *testing.T) {
+	ctx := context.Background()
+	full := NewFakeBatchMetadataSource(3, 4, 5, 6)
+	// The local inbox tracker is behind, only having the first two batches
+	behind := &FakeBatchMetadataSource{batchMessageCounts: full.batchMessageCounts[:2]}
+	newBackend := func(fallback BatchMessageCountSource) (*BlockChallengeBackend, error) {
+		config := DefaultBlockChallengeBackendConfig
+		config.FallbackBatchSource = fallback
+		return NewBlockChallengeBackendFromGlobalStates(
+			ctx,
+			validator.GoGlobalState{BlockHash: testBlockHash(3), SendRoot: testSendRoot(3), Batch: 1},
+			validator.GoGlobalState{Batch: 4},
+			4,
+			&testStreamer{},
+			behind,
+			&config,
+		)
+	}
+	if _, err := newBackend(nil); err == nil {
+		Fail(t, "expected setup to fail without a fallback batch source")
+	}
+	backend, err := newBackend(full)
+	Require(t, err)
+	reference := newTestBlockChallengeBackend(t, full, nil, nil)
+	for _, position := range []uint64{0, 3, 8, 14} {
+		hash, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		expected, err := reference.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "hash mismatch at position", position)
+		}
+	}
+}
+
+func TestExecChallengeSummary(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	segments := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	state := &ChallengeState{
+		Start:       big.NewInt(4),
+		End:         big.NewInt(5),
+		RawSegments: [][32]byte{segments[0], segments[1]},
+		Segments:    []ChallengeSegment{{Hash: segments[0], Position: 4}, {Hash: segments[1], Position: 5}},
+	}
+	summary, err := backend.ExecChallengeSummary(state, 0)
+	Require(t, err)
+	args, err := backend.getExecChallengeArgs(state, 0)
+	Require(t, err)
+	for i, gs := range args.globalStates {
+		for _, expected := range []string{fmt.Sprintf("step %v:", 4+i), gs.BlockHash.String(), common.Hash(args.globalStateHashes[i]).String()} {
+			if !strings.Contains(summary, expected) {
+				Fail(t, "summary", summary, "is missing", expected)
+			}
+		}
+	}
+}
+
+func TestValidateStatusMonotonicity(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	Require(t, backend.ValidateStatusMonotonicity(ctx, 0, backend.tooFarStartsAtPosition+10))
+	Require(t, backend.ValidateStatusMonotonicity(ctx, 2, 2))
+
+	// Statuses can't go backwards in a real backend, so the check is tested with a step that's too far in the middle
+	statuses := func(position uint64) (uint8, error) {
+		if position == 7 {
+			return StatusTooFar, nil
+		}
+		return StatusFinished, nil
+	}
+	if err := checkStatusMonotonicity(ctx, 0, 15, statuses); err == nil {
+		Fail(t, "expected a finished step after a too far one to be detected")
+	}
+}
+
+// countingInboxTracker counts the batch message count reads it serves.
+type countingInboxTracker struct {
+	*FakeBatchMetadataSource
+	reads atomic.Uint64
+}
+
+func (t *countingInboxTracker) GetBatchMessageCount(seqNum uint64) (arbutil.MessageIndex, error) {
+	t.reads.Add(1)
+	return t.FakeBatchMetadataSource.GetBatchMessageCount(seqNum)
+}
+
+func TestBlockChallengeBackendFactoryReorg(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(3, 4, 5, 6, 7, 8)
+	streamer := &testStreamer{}
+	config := DefaultBlockChallengeBackendConfig
+	config.ResultCacheSize = 64
+	factory := NewBlockChallengeBackendFactory(streamer, tracker, &config, 16)
+	initialState := &challengegen.ChallengeManagerInitiatedChallenge{
+		StartState: validator.GoGlobalState{BlockHash: testBlockHash(3), SendRoot: testSendRoot(3), Batch: 1}.AsSolidityStruct(),
+		EndState:   validator.GoGlobalState{Batch: 6}.AsSolidityStruct(),
+	}
+	first, err := factory.New(ctx, initialState, 6)
+	Require(t, err)
+	_, err = first.GetHashRange(ctx, 0, first.tooFarStartsAtPosition)
+	Require(t, err)
+
+	// The reorg moves a message from batch 3 to batch 4, and changes every block
+	tracker.batchMessageCounts[3]--
+	streamer.reorged.Store(true)
+	if err := first.SetRange(ctx, 0, first.tooFarStartsAtPosition-1); err == nil {
+		Fail(t, "expected SetRange to detect the reorg")
+	}
+
+	second, err := factory.New(ctx, initialState, 6)
+	Require(t, err)
+	reference, err := NewBlockChallengeBackendWithConfig(ctx, initialState, 6, streamer, tracker, &config)
+	Require(t, err)
+	for position := uint64(0); position < reference.tooFarStartsAtPosition; position++ {
+		hash, err := second.GetHashAtStep(ctx, position)
+		Require(t, err)
+		expected, err := reference.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "backend from the factory used a stale batch message count at position", position, "after a reorg")
+		}
+	}
+}
+
+func TestBlockChallengeBackendFactory(t *testing.T) {
+	ctx := context.Background()
+	tracker := &countingInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5, 6, 7, 8)}
+	streamer := &testStreamer{}
+	config := DefaultBlockChallengeBackendConfig
+	config.ResultCacheSize = 64
+	factory := NewBlockChallengeBackendFactory(streamer, tracker, &config, 16)
+	initialState := &challengegen.ChallengeManagerInitiatedChallenge{
+		StartState: validator.GoGlobalState{BlockHash: testBlockHash(3), SendRoot: testSendRoot(3), Batch: 1}.AsSolidityStruct(),
+		EndState:   validator.GoGlobalState{Batch: 6}.AsSolidityStruct(),
+	}
+	first, err := factory.New(ctx, initialState, 6)
+	Require(t, err)
+	second, err := factory.New(ctx, initialState, 6)
+	Require(t, err)
+
+	var positions []uint64
+	for position := uint64(0); position < first.tooFarStartsAtPosition; position++ {
+		positions = append(positions, position)
+	}
+	firstHashes, err := first.GetHashesAtSteps(ctx, positions)
+	Require(t, err)
+	reads, lookups := tracker.reads.Load(), streamer.lookups.Load()
+	secondHashes, err := second.GetHashesAtSteps(ctx, positions)
+	Require(t, err)
+	if fmt.Sprint(firstHashes) != fmt.Sprint(secondHashes) {
+		Fail(t, "backends from the same factory disagree on hashes")
+	}
+	if tracker.reads.Load() != reads || streamer.lookups.Load() != lookups {
+		Fail(t, "second backend didn't read through the shared caches")
+	}
+
+	// The shared caches must be safe for backends to use concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		backend, err := factory.New(ctx, initialState, 6)
+		Require(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := backend.GetHashRange(ctx, 0, backend.tooFarStartsAtPosition); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEndResolvesAtBoundary(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	if !backend.EndResolvesAtBoundary() {
+		Fail(t, "expected an end global state at the start of a batch to be at a boundary")
+	}
+	endInfo, err := backend.GetInfoAtStep(backend.tooFarStartsAtPosition - 1)
+	Require(t, err)
+	endGs := endInfo.GlobalState
+	if endGs.Batch != backend.endGs.Batch || endGs.PosInBatch != 0 {
+		Fail(t, "expected the last step to resolve to the start of the end batch, got", endGs)
+	}
+	// Step 6 is message count 9, mid way through batch 2
+	Require(t, backend.SetRange(ctx, 0, 6))
+	if backend.EndResolvesAtBoundary() {
+		Fail(t, "expected an end global state mid batch not to be at a boundary, got", backend.endGs)
+	}
+}
+
+func TestGetInfoAtStep(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	info, err := backend.GetInfoAtStep(5)
+	Require(t, err)
+	// Step 5 is message count 8, the first message of batch 2
+	expected := validator.GoGlobalState{BlockHash: testBlockHash(8), SendRoot: testSendRoot(8), Batch: 2, PosInBatch: 1}
+	if info.Status != StatusFinished || info.GlobalState != expected || info.Batch != 2 {
+		Fail(t, "unexpected step info", info)
+	}
+	info, err = backend.GetInfoAtStep(backend.tooFarStartsAtPosition)
+	Require(t, err)
+	if info != (StepInfo{Status: StatusTooFar}) {
+		Fail(t, "unexpected too far step info", info)
+	}
+}
+
+func TestSubrange(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	sub, err := backend.Subrange(ctx, 2, 9)
+	Require(t, err)
+	for position := uint64(0); position <= 7; position++ {
+		expected, err := backend.GetHashAtStep(ctx, position+2)
+		Require(t, err)
+		hash, err := sub.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "subrange position", position, "hash", hash, "doesn't match parent position", position+2, "hash", expected)
+		}
+	}
+	if !sub.IsTooFar(8) {
+		Fail(t, "expected positions past the end of the subrange to be too far")
+	}
+
+	// A subrange reaching past the parent's too far boundary keeps it
+	sub, err = backend.Subrange(ctx, 2, backend.tooFarStartsAtPosition+5)
+	Require(t, err)
+	if sub.tooFarStartsAtPosition != backend.tooFarStartsAtPosition-2 {
+		Fail(t, "unexpected subrange too far boundary", sub.tooFarStartsAtPosition)
+	}
+	if sub.EndMessageCount() != backend.EndMessageCount() {
+		Fail(t, "expected the subrange to end at the parent's end message count, got", sub.EndMessageCount())
+	}
+
+	// Before SetRange is called, the range ends at MaxUint64
+	sub, err = backend.Subrange(ctx, 2, math.MaxUint64)
+	Require(t, err)
+	if sub.tooFarStartsAtPosition != backend.tooFarStartsAtPosition-2 {
+		Fail(t, "unexpected too far boundary for a subrange to the end of an unranged backend", sub.tooFarStartsAtPosition)
+	}
+
+	Require(t, backend.SetRange(ctx, 2, 9))
+	for _, r := range [][2]uint64{{5, 5}, {6, 3}, {1, 5}, {3, 10}} {
+		if _, err := backend.Subrange(ctx, r[0], r[1]); err == nil {
+			Fail(t, "expected subrange", r, "to be rejected")
+		}
+	}
+}
+
+func TestEndAtInboxOrigin(t *testing.T) {
+	backend, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{},
+		validator.GoGlobalState{},
+		0,
+		&testStreamer{},
+		NewFakeBatchMetadataSource(),
+		&DefaultBlockChallengeBackendConfig,
+	)
+	Require(t, err)
+	info, err := backend.GetInfoAtStep(0)
+	Require(t, err)
+	if info.Status != StatusFinished || info.Batch != 0 || info.GlobalState.PosInBatch != 0 {
+		Fail(t, "expected the end of the challenge to resolve to the inbox origin, got", info)
+	}
+	if !backend.IsTooFar(1) {
+		Fail(t, "expected positions after the inbox origin to be too far")
+	}
+}
+
+func TestRemainingBisectionRounds(t *testing.T) {
+	// The challenge's 13 messages are followed by the first too far position, 14
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 4), nil, nil)
+	if _, err := backend.RemainingBisectionRounds(1); err == nil {
+		Fail(t, "expected a single segment to be rejected")
+	}
+	for _, test := range []struct {
+		start, end, numSegments, rounds uint64
+	}{
+		{0, math.MaxUint64, 2, 4},
+		{0, math.MaxUint64, 14, 1},
+		{0, math.MaxUint64, 13, 2},
+		{0, 9, 3, 2},
+		{0, 10, 3, 3},
+		{4, 5, 2, 0},
+	} {
+		backend.startPosition, backend.endPosition = test.start, test.end
+		rounds, err := backend.RemainingBisectionRounds(test.numSegments)
+		Require(t, err)
+		if rounds != test.rounds {
+			Fail(t, "range", test.start, "to", test.end, "with", test.numSegments, "segments needs", test.rounds, "rounds, got", rounds)
+		}
+	}
+	// The number of steps covered by the rounds would overflow
+	backend.startPosition, backend.endPosition = 0, math.MaxUint64-1
+	rounds, err := backend.RemainingBisectionRounds(1 << 32)
+	Require(t, err)
+	if rounds != 2 {
+		Fail(t, "expected two rounds of 2^32 segments to cover the whole range, got", rounds)
+	}
+}
+
+func TestVerifyBisection(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	hashes, _, err := backend.SegmentsHash(ctx, 0, 15, 4)
+	Require(t, err)
+	_, ok, err := backend.VerifyBisection(ctx, 0, 15, 4, hashes)
+	Require(t, err)
+	if !ok {
+		Fail(t, "expected our own bisection to verify")
+	}
+	opponentHashes := append([]common.Hash{}, hashes...)
+	opponentHashes[2] = common.Hash{1}
+	opponentHashes[3] = common.Hash{2}
+	firstMismatch, ok, err := backend.VerifyBisection(ctx, 0, 15, 4, opponentHashes)
+	Require(t, err)
+	if ok || firstMismatch != 2 {
+		Fail(t, "expected the first mismatch at boundary 2, got", firstMismatch, "ok", ok)
+	}
+	if _, _, err := backend.VerifyBisection(ctx, 0, 15, 4, hashes[:4]); err == nil {
+		Fail(t, "expected too few opponent hashes to be rejected")
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := backend.VerifyBisection(cancelledCtx, 0, 15, 4, hashes); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop verification, got", err)
+	}
+}
+
+func TestStepStatusMetrics(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	backend.EnableStepStatusMetrics(7)
+	for _, position := range []uint64{0, 1, backend.tooFarStartsAtPosition} {
+		_, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+	}
+	if finished, tooFar := backend.finishedStepsCounter.Count(), backend.tooFarStepsCounter.Count(); finished != 2 || tooFar != 1 {
+		Fail(t, "expected 2 finished and 1 too far steps, got", finished, "and", tooFar)
+	}
+	names := []string{"arb/validator/challenge/7/block/steps/finished", "arb/validator/challenge/7/block/steps/toofar"}
+	for _, name := range names {
+		if metrics.DefaultRegistry.Get(name) == nil {
+			Fail(t, "expected metric", name, "to be registered")
+		}
+	}
+	backend.DisableStepStatusMetrics()
+	for _, name := range names {
+		if metrics.DefaultRegistry.Get(name) != nil {
+			Fail(t, "expected metric", name, "to be unregistered")
+		}
+	}
+	// Steps are no longer counted, and disabling again does nothing
+	_, err := backend.GetInfoAtStep(0)
+	Require(t, err)
+	backend.DisableStepStatusMetrics()
+}
+
+func TestBlockHashAtStep(t *testing.T) {
+	ctx := context.Background()
+	tracker := &countingInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5)}
+	backend := newTestBlockChallengeBackend(t, tracker.FakeBatchMetadataSource, nil, nil)
+	backend.inboxTracker = tracker
+	hash, tooFar, err := backend.BlockHashAtStep(ctx, 5)
+	Require(t, err)
+	if reads := tracker.reads.Load(); reads != 0 {
+		Fail(t, "expected no batch metadata reads, got", reads)
+	}
+	info, err := backend.GetInfoAtStep(5)
+	Require(t, err)
+	if tooFar || hash != info.GlobalState.BlockHash {
+		Fail(t, "expected block hash", info.GlobalState.BlockHash, "got", hash, "too far", tooFar)
+	}
+	hash, tooFar, err = backend.BlockHashAtStep(ctx, backend.tooFarStartsAtPosition)
+	Require(t, err)
+	if !tooFar || hash != (common.Hash{}) {
+		Fail(t, "expected the zero hash past the too far boundary, got", hash, "too far", tooFar)
+	}
+}
+
+func TestVerifyAgainstExpected(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	expected := make(map[uint64]common.Hash)
+	for position := uint64(0); position <= backend.tooFarStartsAtPosition; position++ {
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		expected[position] = info.GlobalState.BlockHash
+	}
+	mismatches, err := backend.VerifyAgainstExpected(ctx, expected)
+	Require(t, err)
+	if len(mismatches) != 0 {
+		Fail(t, "expected the backend's own block hashes to match, got mismatches at", mismatches)
+	}
+	expected[7] = common.HexToHash("0x07")
+	expected[2] = common.HexToHash("0x02")
+	expected[backend.tooFarStartsAtPosition] = common.HexToHash("0x01")
+	mismatches, err = backend.VerifyAgainstExpected(ctx, expected)
+	Require(t, err)
+	if want := []uint64{2, 7, backend.tooFarStartsAtPosition}; fmt.Sprint(mismatches) != fmt.Sprint(want) {
+		Fail(t, "expected mismatches at", want, "got", mismatches)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := backend.VerifyAgainstExpected(cancelledCtx, expected); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop verification, got", err)
+	}
+}
+
+func TestZeroBlockChallengeBackendConfig(t *testing.T) {
+	ctx := context.Background()
+	expected, err := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, &DefaultBlockChallengeBackendConfig).GetHashAtStep(ctx, 4)
+	Require(t, err)
+	hash, err := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil).GetHashAtStep(ctx, 4)
+	Require(t, err)
+	if hash != expected {
+		Fail(t, "unexpected hash from a backend with a nil config", hash)
+	}
+
+	// A zero retry backoff is the default backoff, not no backoff
+	clock := &fakeClock{}
+	tracker := &flakyInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5), failuresLeft: map[uint64]int{2: 1}}
+	newTestBlockChallengeBackend(t, tracker, nil, &BlockChallengeBackendConfig{SetupRetries: 1, Clock: clock})
+	if fmt.Sprint(clock.waits) != fmt.Sprint([]time.Duration{defaultSetupRetryBackoff}) {
+		Fail(t, "unexpected retry backoffs", clock.waits)
+	}
+}
+
+func TestChallengeStateRoot(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 6), nil, nil)
+	positions := segmentPositions(2, 15, 4)
+	hashes, expectedRoot, err := backend.SegmentsHash(ctx, 2, 15, 4)
+	Require(t, err)
+	segments := make([]ChallengeSegment, len(positions))
+	for i := range segments {
+		segments[i] = ChallengeSegment{Hash: hashes[i], Position: positions[i]}
+	}
+	root, err := backend.ChallengeStateRoot(ctx, segments)
+	Require(t, err)
+	if root != expectedRoot {
+		Fail(t, "challenge state root", root, "doesn't match the segments hash", expectedRoot)
+	}
+	segments[1], segments[2] = segments[2], segments[1]
+	if _, err := backend.ChallengeStateRoot(ctx, segments); err == nil {
+		Fail(t, "expected out of order segments to be rejected")
+	}
+	if _, err := backend.ChallengeStateRoot(ctx, segments[:1]); err == nil {
+		Fail(t, "expected a single segment to be rejected")
+	}
+}
+
+func TestCoversBatchRange(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	if !backend.CoversBatchRange(1, 3) {
+		Fail(t, "expected the challenge to cover batches 1 to 3")
+	}
+	if backend.CoversBatchRange(1, 2) || backend.CoversBatchRange(0, 3) {
+		Fail(t, "expected the challenge not to cover other batch ranges")
+	}
+	// Bisecting doesn't change which batches the challenge was created over
+	Require(t, backend.SetRange(context.Background(), 0, 4))
+	if !backend.CoversBatchRange(1, 3) {
+		Fail(t, "expected the challenge to still cover batches 1 to 3 after bisecting")
+	}
+}
+
+func TestWalkSegment(t *testing.T) {
+	ctx := context.Background()
+	tracker := &countingInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 1, 4, 1, 5, 9, 2)}
+	backend := newTestBlockChallengeBackend(t, tracker.FakeBatchMetadataSource, nil, nil)
+	backend.inboxTracker = tracker
+	start, end := uint64(2), backend.tooFarStartsAtPosition+1
+	var positions []uint64
+	var hashes []common.Hash
+	err := backend.WalkSegment(ctx, start, end, func(position uint64, hash common.Hash, status uint8) error {
+		positions = append(positions, position)
+		hashes = append(hashes, hash)
+		if (status == StatusTooFar) != backend.IsTooFar(position) {
+			Fail(t, "unexpected status", status, "at position", position)
+		}
+		return nil
+	})
+	Require(t, err)
+	// Walking reads each batch's metadata about once, rather than binary searching at every position
+	if reads := tracker.reads.Load(); reads > uint64(len(tracker.batchMessageCounts))+3 {
+		Fail(t, "expected shared batch metadata reads, got", reads)
+	}
+	if uint64(len(positions)) != end-start+1 {
+		Fail(t, "expected", end-start+1, "positions, got", len(positions))
+	}
+	for i, position := range positions {
+		expected, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if position != start+uint64(i) || hashes[i] != expected {
+			Fail(t, "unexpected hash at position", position)
+		}
+	}
+
+	stop := errors.New("stop")
+	walked := 0
+	err = backend.WalkSegment(ctx, start, end, func(uint64, common.Hash, uint8) error {
+		walked++
+		if walked == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || walked != 3 {
+		Fail(t, "expected the walk to stop at the callback's error, got", err, "after", walked, "steps")
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := backend.WalkSegment(cancelledCtx, start, end, func(uint64, common.Hash, uint8) error { return nil }); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the walk, got", err)
+	}
+}
+
+func TestCollapsedRange(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	if backend.IsResolved() {
+		Fail(t, "expected a new challenge not to be resolved")
+	}
+	Require(t, backend.SetRange(ctx, 4, 5))
+	if backend.IsResolved() {
+		Fail(t, "expected a single step range not to be resolved")
+	}
+	Require(t, backend.SetRange(ctx, 4, 4))
+	if !backend.IsResolved() || backend.IsSingleStep() {
+		Fail(t, "expected a collapsed range to be resolved and not a single step")
+	}
+	_, err := backend.GetHashAtStep(ctx, 4)
+	Require(t, err)
+	if fraction := backend.ProgressFraction(); fraction != 1 {
+		Fail(t, "expected a collapsed range to be fully progressed, got", fraction)
+	}
+	rounds, err := backend.RemainingBisectionRounds(2)
+	Require(t, err)
+	if rounds != 0 {
+		Fail(t, "expected no bisection rounds to remain, got", rounds)
+	}
+}
+
+func TestBuildHashIndex(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	end := backend.tooFarStartsAtPosition + 2
+	index, err := backend.BuildHashIndex(ctx, 1, end)
+	Require(t, err)
+	// Only the first too far position is indexed, as they share a hash
+	if uint64(len(index)) != backend.tooFarStartsAtPosition {
+		Fail(t, "expected", backend.tooFarStartsAtPosition, "indexed hashes, got", len(index))
+	}
+	for position := uint64(1); position <= end; position++ {
+		hash, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		expected := min(position, backend.tooFarStartsAtPosition)
+		if index[hash] != expected {
+			Fail(t, "expected the hash at position", position, "to be indexed at", expected, "got", index[hash])
+		}
+	}
+	if _, err := backend.BuildHashIndex(ctx, 0, maxHashIndexSteps); err == nil {
+		Fail(t, "expected a range over the cap to be rejected")
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := backend.BuildHashIndex(cancelledCtx, 1, end); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the build, got", err)
+	}
+}
+
+func TestCompareBackends(t *testing.T) {
+	ctx := context.Background()
+	batchSizes := make([]uint64, 40)
+	for i := range batchSizes {
+		batchSizes[i] = 20
+	}
+	a := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(batchSizes...), nil, nil)
+	b := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(batchSizes...), nil, nil)
+	end := a.tooFarStartsAtPosition + 1
+	_, diverged, err := CompareBackends(ctx, a, b, 0, end)
+	Require(t, err)
+	if diverged {
+		Fail(t, "expected identical backends not to diverge")
+	}
+	// Batch 31 ending a message early makes the global state at message count 639, position 619, diverge
+	batchSizes[31] = 19
+	batchSizes[32] = 21
+	b = newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(batchSizes...), nil, nil)
+	firstDivergence, diverged, err := CompareBackends(ctx, a, b, 1, end)
+	Require(t, err)
+	if !diverged || firstDivergence != 619 {
+		Fail(t, "expected a divergence at position 619, got", firstDivergence, "diverged", diverged)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := CompareBackends(cancelledCtx, a, b, 0, end); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the comparison, got", err)
+	}
+}
+
+func TestVerifyStartBlock(t *testing.T) {
+	config := DefaultBlockChallengeBackendConfig
+	config.VerifyStartBlock = true
+	newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, &config)
+	// The challenge's start block hash is the unreorged one, which the reorged chain doesn't have
+	reorged := &testStreamer{}
+	reorged.reorged.Store(true)
+	if _, err := tryNewTestBlockChallengeBackend(NewFakeBatchMetadataSource(3, 4, 5), reorged, &config); err == nil {
+		Fail(t, "expected a start global state with the wrong block hash to be rejected")
+	}
+}
+
+func TestStepBudget(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 1, 4, 1, 5, 9, 2), nil, nil)
+
+	var expected []string
+	Require(t, backend.Replay(ctx, func(position uint64, gs validator.GoGlobalState, status uint8) error {
+		expected = append(expected, fmt.Sprint(position, gs, status))
+		return nil
+	}))
+	var replayed []string
+	calls := 0
+	for next, done := uint64(0), false; !done; calls++ {
+		var err error
+		next, done, err = backend.ReplayWithBudget(ctx, next, 3, func(position uint64, gs validator.GoGlobalState, status uint8) error {
+			replayed = append(replayed, fmt.Sprint(position, gs, status))
+			return nil
+		})
+		Require(t, err)
+	}
+	if fmt.Sprint(replayed) != fmt.Sprint(expected) {
+		Fail(t, "resumed replay doesn't match an uninterrupted replay")
+	}
+	if expectedCalls := (len(expected) + 2) / 3; calls != expectedCalls {
+		Fail(t, "expected", expectedCalls, "budgeted replay calls, got", calls)
+	}
+
+	end := backend.tooFarStartsAtPosition + 2
+	expected = nil
+	Require(t, backend.WalkSegment(ctx, 1, end, func(position uint64, hash common.Hash, status uint8) error {
+		expected = append(expected, fmt.Sprint(position, hash, status))
+		return nil
+	}))
+	var walked []string
+	for next, done := uint64(1), false; !done; {
+		var err error
+		next, done, err = backend.WalkSegmentWithBudget(ctx, next, end, 4, func(position uint64, hash common.Hash, status uint8) error {
+			walked = append(walked, fmt.Sprint(position, hash, status))
+			return nil
+		})
+		Require(t, err)
+	}
+	if fmt.Sprint(walked) != fmt.Sprint(expected) {
+		Fail(t, "resumed walk doesn't match an uninterrupted walk")
+	}
+	if _, _, err := backend.ReplayWithBudget(ctx, 0, 0, nil); err == nil {
+		Fail(t, "expected a zero budget to be rejected")
+	}
+}
+
+func TestSingleBatchChallenge(t *testing.T) {
+	tracker := NewFakeBatchMetadataSource(1, 2, 3)
+	// The challenged assertion starts after the first message of batch 2 and stops after its second
+	backend, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{BlockHash: testBlockHash(4), SendRoot: testSendRoot(4), Batch: 2, PosInBatch: 1},
+		validator.GoGlobalState{BlockHash: testBlockHash(5), SendRoot: testSendRoot(5), Batch: 2, PosInBatch: 2},
+		3,
+		&testStreamer{},
+		tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	Require(t, err)
+	if !backend.SpansSingleBatch() {
+		Fail(t, "expected the challenge to span a single batch")
+	}
+	info, err := backend.GetInfoAtStep(1)
+	Require(t, err)
+	expected := validator.GoGlobalState{BlockHash: testBlockHash(5), SendRoot: testSendRoot(5), Batch: 2, PosInBatch: 2}
+	if info.GlobalState != expected {
+		Fail(t, "unexpected global state", info.GlobalState, "part way through the batch")
+	}
+	if newTestBlockChallengeBackend(t, tracker, nil, nil).SpansSingleBatch() {
+		Fail(t, "expected a challenge over several batches not to span a single batch")
+	}
+}
+
+func TestExportTrace(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	var csvTrace strings.Builder
+	Require(t, backend.ExportTrace(ctx, &csvTrace, TraceFormatCSV))
+	records, err := csv.NewReader(strings.NewReader(csvTrace.String())).ReadAll()
+	Require(t, err)
+	if uint64(len(records)) != backend.tooFarStartsAtPosition+1 || fmt.Sprint(records[0]) != fmt.Sprint(traceCSVHeader) {
+		Fail(t, "unexpected CSV trace", records)
+	}
+	for position, record := range records[1:] {
+		expected, err := backend.GetHashAtStep(ctx, uint64(position))
+		Require(t, err)
+		if record[0] != fmt.Sprint(position) || record[6] != expected.Hex() {
+			Fail(t, "unexpected CSV trace row", record, "at position", position)
+		}
+	}
+
+	var jsonTrace strings.Builder
+	Require(t, backend.ExportTrace(ctx, &jsonTrace, TraceFormatJSON))
+	decoder := json.NewDecoder(strings.NewReader(jsonTrace.String()))
+	for position := uint64(0); position < backend.tooFarStartsAtPosition; position++ {
+		var step traceStep
+		Require(t, decoder.Decode(&step))
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		expected, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if step.Position != position || step.Batch != info.Batch || step.BlockHash != info.GlobalState.BlockHash || step.Hash != expected {
+			Fail(t, "unexpected JSON trace step", step, "at position", position)
+		}
+	}
+	if decoder.More() {
+		Fail(t, "expected no JSON trace steps past the too far boundary")
+	}
+
+	if err := backend.ExportTrace(ctx, io.Discard, "xml"); err == nil {
+		Fail(t, "expected an unknown trace format to be rejected")
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := backend.ExportTrace(cancelledCtx, io.Discard, TraceFormatJSON); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the export, got", err)
+	}
+
+	// The CSV header is written even if no steps are
+	csvTrace.Reset()
+	if err := backend.ExportTrace(cancelledCtx, &csvTrace, TraceFormatCSV); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the export, got", err)
+	}
+	if csvTrace.String() != strings.Join(traceCSVHeader, ",")+"\n" {
+		Fail(t, "expected only the CSV header from a cancelled export, got", csvTrace.String())
+	}
+	csvTrace.Reset()
+	pruned := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), &prunedStreamer{prunedBelow: 10}, nil)
+	if err := pruned.ExportTrace(ctx, &csvTrace, TraceFormatCSV); err == nil {
+		Fail(t, "expected a pruned first step to fail the export")
+	}
+	if csvTrace.String() != strings.Join(traceCSVHeader, ",")+"\n" {
+		Fail(t, "expected only the CSV header from an export whose first step failed, got", csvTrace.String())
+	}
+
+	// The last step of a challenge ending part way through a batch is at the start of the next batch
+	midBatch := newMidBatchEndTestBackend(t, &testStreamer{})
+	jsonTrace.Reset()
+	Require(t, midBatch.ExportTrace(ctx, &jsonTrace, TraceFormatJSON))
+	decoder = json.NewDecoder(strings.NewReader(jsonTrace.String()))
+	for position := uint64(0); position < midBatch.tooFarStartsAtPosition; position++ {
+		var step traceStep
+		Require(t, decoder.Decode(&step))
+		info, err := midBatch.GetInfoAtStep(position)
+		Require(t, err)
+		if step.Batch != info.GlobalState.Batch || step.PosInBatch != info.GlobalState.PosInBatch {
+			Fail(t, "JSON trace step", step, "doesn't match the global state", info.GlobalState, "at position", position)
+		}
+	}
+}
+
+func TestEndMessageCount(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	if backend.EndMessageCount() != 12 {
+		Fail(t, "expected the challenge to end after 12 messages, got", backend.EndMessageCount())
+	}
+	if backend.GetMessageCountAtStep(backend.tooFarStartsAtPosition-1) != backend.EndMessageCount() {
+		Fail(t, "expected the last position before the too far boundary to be at the end message count")
+	}
+	sub, err := backend.Subrange(context.Background(), 1, 4)
+	Require(t, err)
+	if sub.EndMessageCount() != backend.GetMessageCountAtStep(4) {
+		Fail(t, "unexpected subrange end message count", sub.EndMessageCount())
+	}
+}
+
+func TestPositionMessageCountConversion(t *testing.T) {
+	// The challenge starts after batch 0's 3 messages, and ends after batch 2's, at message count 12
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	tooFar := backend.tooFarStartsAtPosition
+	tests := []struct {
+		name        string
+		position    uint64
+		msgCount    arbutil.MessageIndex
+		inChallenge bool
+	}{
+		{"before the start", 0, 2, false},
+		{"the start", 0, 3, true},
+		{"the last in range count", tooFar - 1, 12, true},
+		{"the too far boundary", tooFar, 13, false},
+	}
+	for _, test := range tests {
+		position, ok := backend.MessageCountToPosition(test.msgCount)
+		if ok != test.inChallenge || (ok && position != test.position) {
+			Fail(t, test.name, "expected message count", test.msgCount, "at position", test.position, test.inChallenge, "got", position, ok)
+		}
+		if test.msgCount >= backend.startMsgCount {
+			if msgCount := backend.PositionToMessageCount(test.position); msgCount != test.msgCount {
+				Fail(t, test.name, "expected position", test.position, "at message count", test.msgCount, "got", msgCount)
+			}
+		}
+	}
+	// Positions past the too far boundary are clamped to it, even if they'd overflow the message count
+	for _, position := range []uint64{tooFar + 1, math.MaxUint64} {
+		if msgCount := backend.PositionToMessageCount(position); msgCount != 13 {
+			Fail(t, "expected position", position, "to be clamped to the too far boundary's message count, got", msgCount)
+		}
+	}
+}
+
+func TestExecChallengeSingleSegment(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	hash := common.HexToHash("0x01")
+	state := &ChallengeState{
+		Start:       big.NewInt(3),
+		End:         big.NewInt(3),
+		Segments:    []ChallengeSegment{{Hash: hash, Position: 3}},
+		RawSegments: [][32]byte{hash},
+	}
+	// There's no second segment to end the selected segment at
+	_, err := backend.getExecChallengeArgs(state, 0)
+	if err == nil || !strings.Contains(err.Error(), "at least 2 are required") {
+		Fail(t, "expected a single segment challenge state to be rejected, got", err)
+	}
+	// The core is never used, as the segments are rejected before anything is submitted.
+	if _, err := backend.IssueExecChallenge(&challengeCore{}, state, 0, 1); err == nil {
+		Fail(t, "expected an exec challenge of a single segment challenge state to be rejected")
+	}
+	if _, err := backend.SubmitExecChallenge(&challengeCore{}, state, 0, 1); err == nil {
+		Fail(t, "expected submitting an exec challenge of a single segment challenge state to be rejected")
+	}
+}
+
+func TestPrefetchBatches(t *testing.T) {
+	ctx := context.Background()
+	tracker := &countingInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5, 6, 7, 8)}
+	factory := NewBlockChallengeBackendFactory(&testStreamer{}, tracker, &DefaultBlockChallengeBackendConfig, 16)
+	initialState := &challengegen.ChallengeManagerInitiatedChallenge{
+		StartState: validator.GoGlobalState{BlockHash: testBlockHash(3), SendRoot: testSendRoot(3), Batch: 1}.AsSolidityStruct(),
+		EndState:   validator.GoGlobalState{Batch: 6}.AsSolidityStruct(),
+	}
+	backend, err := factory.New(ctx, initialState, 6)
+	Require(t, err)
+	Require(t, backend.PrefetchBatches(ctx))
+	reads := tracker.reads.Load()
+	_, err = backend.GetHashRange(ctx, 0, backend.tooFarStartsAtPosition)
+	Require(t, err)
+	if tracker.reads.Load() != reads {
+		Fail(t, "expected every batch read after prefetching to be cached")
+	}
+
+	tracker.FailBatch(2, errors.New("batch 2 failed"))
+	tracker.FailBatch(4, errors.New("batch 4 failed"))
+	backend = newTestBlockChallengeBackend(t, tracker, nil, nil)
+	err = backend.PrefetchBatches(ctx)
+	if err == nil || !strings.Contains(err.Error(), "batch 2 failed") || !strings.Contains(err.Error(), "batch 4 failed") {
+		Fail(t, "expected every failed batch to be reported, got", err)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := backend.PrefetchBatches(cancelledCtx); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop prefetching, got", err)
+	}
+}
+
+func benchmarkBisectionAfterPrefetch(b *testing.B, prefetch bool) {
+	ctx := context.Background()
+	batchSizes := make([]uint64, 64)
+	for i := range batchSizes {
+		batchSizes[i] = 16
+	}
+	tracker := NewFakeBatchMetadataSource(batchSizes...)
+	tracker.delay = 10 * time.Microsecond
+	initialState := &challengegen.ChallengeManagerInitiatedChallenge{
+		StartState: validator.GoGlobalState{BlockHash: testBlockHash(16), SendRoot: testSendRoot(16), Batch: 1}.AsSolidityStruct(),
+		EndState:   validator.GoGlobalState{Batch: 64}.AsSolidityStruct(),
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		factory := NewBlockChallengeBackendFactory(&testStreamer{}, tracker, &DefaultBlockChallengeBackendConfig, 64)
+		backend, err := factory.New(ctx, initialState, 64)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if prefetch {
+			if err := backend.PrefetchBatches(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		if _, err := backend.BisectionTree(ctx, 8, 2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBisectionWithoutPrefetch(b *testing.B) {
+	benchmarkBisectionAfterPrefetch(b, false)
+}
+
+func BenchmarkBisectionWithPrefetch(b *testing.B) {
+	benchmarkBisectionAfterPrefetch(b, true)
+}
+
+func TestSearchBatchRejectsInvertedBounds(t *testing.T) {
+	tracker := &countingInboxTracker{FakeBatchMetadataSource: NewFakeBatchMetadataSource(3, 4, 5)}
+	backend := newTestBlockChallengeBackend(t, tracker.FakeBatchMetadataSource, nil, nil)
+	backend.inboxTracker = tracker
+	backend.config = &BlockChallengeBackendConfig{StrictBatchOrdering: true}
+	// A range whose start state is after its end state, as a bug in updating the range could leave it
+	backend.startGs.Batch, backend.endGs.Batch = 3, 2
+	_, _, err := backend.BatchAtStep(context.Background(), 4)
+	if err == nil || !strings.Contains(err.Error(), "message count 7 with low bound 3 above high bound 2") {
+		Fail(t, "expected inverted search bounds to be rejected, got", err)
+	}
+	if reads := tracker.reads.Load(); reads != 0 {
+		Fail(t, "expected no batch metadata reads with inverted search bounds, got", reads)
+	}
+}
+
+func TestBlockStateHashPrefixes(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(3, 4, 5)
+	config := DefaultBlockChallengeBackendConfig
+	config.HashPrefixes = BlockStateHashPrefixes{Finished: "Fork block state:", TooFar: "Fork block state, too far:"}
+	backend := newTestBlockChallengeBackend(t, tracker, nil, &config)
+	info, err := backend.GetInfoAtStep(2)
+	Require(t, err)
+	hash, err := backend.GetHashAtStep(ctx, 2)
+	Require(t, err)
+	if expected := crypto.Keccak256Hash([]byte("Fork block state:"), info.GlobalState.Hash().Bytes()); hash != expected {
+		Fail(t, "expected finished hash", expected, "with the custom prefix, got", hash)
+	}
+	hash, err = backend.GetHashAtStep(ctx, backend.tooFarStartsAtPosition)
+	Require(t, err)
+	if expected := crypto.Keccak256Hash([]byte("Fork block state, too far:")); hash != expected {
+		Fail(t, "expected too far hash", expected, "with the custom prefix, got", hash)
+	}
+	// The errored prefix wasn't overridden, so it's the contract's
+	errored, err := blockStateHashWithPrefixes(nil, config.HashPrefixes, info.GlobalState, StatusErrored)
+	Require(t, err)
+	expected, err := blockStateHash(info.GlobalState, StatusErrored)
+	Require(t, err)
+	if errored != expected {
+		Fail(t, "expected the default errored prefix, got hash", errored)
+	}
+	// Hashes computed over a range use the custom prefixes too
+	hashes, err := backend.GetHashRange(ctx, 0, backend.tooFarStartsAtPosition+1)
+	Require(t, err)
+	for position, hash := range hashes {
+		expected, err := backend.GetHashAtStep(ctx, uint64(position))
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "range hash at position", position, "doesn't use the custom prefixes")
+		}
+	}
+	defaultHash, err := newTestBlockChallengeBackend(t, tracker, nil, nil).GetHashAtStep(ctx, 2)
+	Require(t, err)
+	if defaultHash == hashes[2] {
+		Fail(t, "expected the custom prefix to change the hash")
+	}
+}
+
+func TestVerifyBatchMonotonicity(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewFakeBatchMetadataSource(3, 4, 0, 5, 6)
+	backend := newTestBlockChallengeBackend(t, tracker, nil, nil)
+	Require(t, backend.VerifyBatchMonotonicity(ctx))
+
+	tracker.batchMessageCounts[3] = 2
+	err := backend.VerifyBatchMonotonicity(ctx)
+	if !errors.Is(err, ErrBatchMessageCountsNotMonotonic) || !strings.Contains(err.Error(), "batch 3 has message count 2 but the previous batch 2 has 7") {
+		Fail(t, "expected the first out of order batch pair to be reported, got", err)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := backend.VerifyBatchMonotonicity(cancelledCtx); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop verification, got", err)
+	}
+}
+
+func TestMessagesBetween(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	last := backend.tooFarStartsAtPosition - 1
+	messages, err := backend.MessagesBetween(2, last)
+	Require(t, err)
+	if arbutil.MessageIndex(messages) != backend.GetMessageCountAtStep(last)-backend.GetMessageCountAtStep(2) {
+		Fail(t, "unexpected messages between positions 2 and", last, "got", messages)
+	}
+	for _, r := range [][2]uint64{{3, 2}, {2, last + 1}, {last + 1, last + 2}} {
+		if _, err := backend.MessagesBetween(r[0], r[1]); err == nil {
+			Fail(t, "expected positions", r, "to be rejected")
+		}
+	}
+}
+
+func TestDistinctBatchesInRange(t *testing.T) {
+	ctx := context.Background()
+	streamer := &testStreamer{}
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 1, 4, 1, 5, 9, 2), streamer, testCacheConfig(0))
+	for _, test := range []struct {
+		start, end uint64
+	}{{0, 0}, {0, 5}, {1, 12}, {4, backend.tooFarStartsAtPosition + 3}} {
+		batches, err := backend.DistinctBatchesInRange(ctx, test.start, test.end)
+		Require(t, err)
+		var expected []uint64
+		for position := test.start; position <= test.end && !backend.IsTooFar(position); position++ {
+			batch, _, err := backend.BatchAtStep(ctx, position)
+			Require(t, err)
+			if len(expected) == 0 || expected[len(expected)-1] != batch {
+				expected = append(expected, batch)
+			}
+		}
+		if fmt.Sprint(batches) != fmt.Sprint(expected) {
+			Fail(t, "expected batches", expected, "from position", test.start, "to", test.end, "got", batches)
+		}
+	}
+	if lookups := streamer.lookups.Load(); lookups != 0 {
+		Fail(t, "expected no block lookups, got", lookups)
+	}
+	batches, err := backend.DistinctBatchesInRange(ctx, backend.tooFarStartsAtPosition, backend.tooFarStartsAtPosition+1)
+	Require(t, err)
+	if len(batches) != 0 {
+		Fail(t, "expected no batches past the too far boundary, got", batches)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := backend.DistinctBatchesInRange(cancelledCtx, 0, 5); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the walk, got", err)
+	}
+}
+
+func TestVerifyExecChallengeArgs(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	ourStart, err := backend.GetHashAtStep(ctx, 4)
+	Require(t, err)
+	ourEnd, err := backend.GetHashAtStep(ctx, 5)
+	Require(t, err)
+	newState := func(endHash common.Hash) *ChallengeState {
+		segments := []common.Hash{common.HexToHash("0x01"), ourStart, endHash}
+		state := &ChallengeState{Start: big.NewInt(3), End: big.NewInt(5)}
+		for i, hash := range segments {
+			state.Segments = append(state.Segments, ChallengeSegment{Hash: hash, Position: 3 + uint64(i)})
+			state.RawSegments = append(state.RawSegments, hash)
+		}
+		return state
+	}
+	onChainStateHash := func(state *ChallengeState) common.Hash {
+		segments := make([]common.Hash, len(state.RawSegments))
+		for i, segment := range state.RawSegments {
+			segments[i] = segment
+		}
+		return hashChallengeState(state.Start, new(big.Int).Sub(state.End, state.Start), segments)
+	}
+
+	state := newState(common.HexToHash("0x02"))
+	args, err := backend.getExecChallengeArgs(state, 1)
+	Require(t, err)
+	Require(t, backend.verifyExecChallengeArgs(state, 1, args, onChainStateHash(state)))
+	// The contract has moved on to different segments
+	if err := backend.verifyExecChallengeArgs(state, 1, args, common.HexToHash("0x03")); err == nil {
+		Fail(t, "expected a mismatched on-chain challenge state hash to be rejected")
+	}
+	// We agree with the end of the segment, so the contract would reject the challenge
+	state = newState(ourEnd)
+	args, err = backend.getExecChallengeArgs(state, 1)
+	Require(t, err)
+	if err := backend.verifyExecChallengeArgs(state, 1, args, onChainStateHash(state)); err == nil {
+		Fail(t, "expected a segment we agree with the end of to be rejected")
+	}
+	// We disagree with the start of the segment
+	state = newState(common.HexToHash("0x02"))
+	state.RawSegments[1] = common.HexToHash("0x04")
+	if err := backend.verifyExecChallengeArgs(state, 1, args, onChainStateHash(state)); err == nil {
+		Fail(t, "expected a segment we disagree with the start of to be rejected")
+	}
+}
+
+// testChain is an in-memory chain of blocks, one per message, whose hashes each commit to their parent's, along
+// with batches posting its messages. It's a more realistic scaffold for end to end tests than testStreamer.
+type testChain struct {
+	results []execution.MessageResult
+	tracker *FakeBatchMetadataSource
+}
+
+// newTestChain builds a chain with the given batches, with a block for every message the batches post.
+func newTestChain(batchSizes ...uint64) *testChain {
+	chain := &testChain{tracker: NewFakeBatchMetadataSource(batchSizes...)}
+	// The result at message count zero is the genesis block
+	parentHash := crypto.Keccak256Hash([]byte("genesis"))
+	chain.results = append(chain.results, execution.MessageResult{BlockHash: parentHash})
+	msgCount := chain.tracker.batchMessageCounts[len(chain.tracker.batchMessageCounts)-1]
+	for count := arbutil.MessageIndex(1); count <= msgCount; count++ {
+		blockHash := crypto.Keccak256Hash(parentHash.Bytes(), binary.BigEndian.AppendUint64(nil, uint64(count)))
+		chain.results = append(chain.results, execution.MessageResult{BlockHash: blockHash, SendRoot: testSendRoot(count)})
+		parentHash = blockHash
+	}
+	return chain
+}
+
+func (c *testChain) SetBlockValidator(*BlockValidator) {}
+
+func (c *testChain) GetProcessedMessageCount() (arbutil.MessageIndex, error) {
+	return arbutil.MessageIndex(len(c.results) - 1), nil
+}
+
+func (c *testChain) GetMessage(arbutil.MessageIndex) (*arbostypes.MessageWithMetadata, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testChain) ResultAtCount(count arbutil.MessageIndex) (*execution.MessageResult, error) {
+	if count >= arbutil.MessageIndex(len(c.results)) {
+		return nil, fmt.Errorf("no block after message count %v", count)
+	}
+	res := c.results[count]
+	return &res, nil
+}
+
+func (c *testChain) PauseReorgs()                     {}
+func (c *testChain) ResumeReorgs()                    {}
+func (c *testChain) ChainConfig() *params.ChainConfig { return &params.ChainConfig{} }
+
+// newBackend creates a backend challenging from the start of the given batch to the end of the chain's last batch.
+func (c *testChain) newBackend(t *testing.T, startBatch uint64) *BlockChallengeBackend {
+	t.Helper()
+	batchCount := uint64(len(c.tracker.batchMessageCounts))
+	startMsgCount := c.tracker.batchMessageCounts[startBatch-1]
+	startRes := c.results[startMsgCount]
+	backend, err := NewBlockChallengeBackendFromGlobalStates(
+		context.Background(),
+		validator.GoGlobalState{BlockHash: startRes.BlockHash, SendRoot: startRes.SendRoot, Batch: startBatch},
+		validator.GoGlobalState{Batch: batchCount},
+		batchCount,
+		c,
+		c.tracker,
+		&DefaultBlockChallengeBackendConfig,
+	)
+	Require(t, err)
+	return backend
+}
+
+func TestBlockChallengeBackendOverChain(t *testing.T) {
+	ctx := context.Background()
+	batchSizes := []uint64{2, 3, 1, 4, 2}
+	chain := newTestChain(batchSizes...)
+	backend := chain.newBackend(t, 1)
+
+	// A backend over an identical chain built again produces the same hashes
+	hashes, err := backend.GetHashRange(ctx, 0, backend.tooFarStartsAtPosition+1)
+	Require(t, err)
+	rebuilt := newTestChain(batchSizes...).newBackend(t, 1)
+	for position, hash := range hashes {
+		rebuiltHash, err := rebuilt.GetHashAtStep(ctx, uint64(position))
+		Require(t, err)
+		if rebuiltHash != hash {
+			Fail(t, "hash at position", position, "changed when the chain was rebuilt")
+		}
+	}
+
+	Require(t, backend.SetRange(ctx, 1, 8))
+	seen := make(map[common.Hash]bool)
+	for position := uint64(1); position <= 8; position++ {
+		info, err := backend.GetInfoAtStep(position)
+		Require(t, err)
+		// Each position is one message, and so one block, after the last
+		count := backend.GetMessageCountAtStep(position)
+		if info.GlobalState.BlockHash != chain.results[count].BlockHash || seen[info.GlobalState.BlockHash] {
+			Fail(t, "position", position, "isn't at the block after message count", count)
+		}
+		seen[info.GlobalState.BlockHash] = true
+		hash, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != hashes[position] {
+			Fail(t, "hash at position", position, "changed when the range was narrowed")
+		}
+	}
+}
+
+func TestPositionBatchKind(t *testing.T) {
+	ctx := context.Background()
+	// Batch 1 is positions 0 to 3, batch 2 positions 4 to 8, and the state after batch 2 is in batch 3
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	for position, expected := range []BatchKind{BatchKindStart, BatchKindStart, BatchKindStart, BatchKindStart, BatchKindInterior, BatchKindInterior, BatchKindInterior, BatchKindInterior, BatchKindInterior, BatchKindEnd, BatchKindTooFar} {
+		kind, err := backend.PositionBatchKind(ctx, uint64(position))
+		Require(t, err)
+		if kind != expected {
+			Fail(t, "expected position", position, "to be of batch kind", expected, "got", kind)
+		}
+	}
+}
+
+func TestExecChallengeArgsCopySegments(t *testing.T) {
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	segments := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	state := &ChallengeState{
+		Start:       big.NewInt(3),
+		End:         big.NewInt(4),
+		Segments:    []ChallengeSegment{{Hash: segments[0], Position: 3}, {Hash: segments[1], Position: 4}},
+		RawSegments: [][32]byte{segments[0], segments[1]},
+	}
+	args, err := backend.getExecChallengeArgs(state, 0)
+	Require(t, err)
+	state.RawSegments[0] = common.HexToHash("0x03")
+	state.Start.SetUint64(100)
+	if args.selection.OldSegments[0] != segments[0] || args.selection.OldSegmentsStart.Uint64() != 3 {
+		Fail(t, "modifying the challenge state changed the exec challenge's segment selection", args.selection)
+	}
+}
+
+func TestResolutionEstimate(t *testing.T) {
+	ctx := context.Background()
+	// The challenge's 13 messages are followed by the first too far position, 14
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5, 4), nil, nil)
+	if _, _, err := backend.ResolutionEstimate(1); err == nil {
+		Fail(t, "expected a single segment to be rejected")
+	}
+	check := func(numSegments uint64, expectedRounds uint64, expectedTransactions uint64) {
+		t.Helper()
+		rounds, transactions, err := backend.ResolutionEstimate(numSegments)
+		Require(t, err)
+		if rounds != expectedRounds || transactions != expectedTransactions {
+			Fail(t, "expected", expectedRounds, "rounds and", expectedTransactions, "transactions, got", rounds, "and", transactions)
+		}
+	}
+	// Before the range is set, it's unbounded, so the estimate is up to the too far boundary
+	check(2, 4, 5)
+	Require(t, backend.SetRange(ctx, 3, 4))
+	check(2, 0, 1)
+	Require(t, backend.SetRange(ctx, 3, 3))
+	check(2, 0, 0)
+}
+
+func testHasherConfig(hasher validator.Keccak256Hasher) *BlockChallengeBackendConfig {
+	config := DefaultBlockChallengeBackendConfig
+	config.Hasher = hasher
+	return &config
+}
+
+func TestHasher(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Uint64
+	countingHasher := func(data ...[]byte) common.Hash {
+		calls.Add(1)
+		return crypto.Keccak256Hash(data...)
+	}
+	backend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, testHasherConfig(countingHasher))
+	defaultBackend := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	for position := uint64(0); position <= backend.tooFarStartsAtPosition; position++ {
+		hash, err := backend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		expected, err := defaultBackend.GetHashAtStep(ctx, position)
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "hash at position", position, "from the configured hasher doesn't match the default")
+		}
+	}
+	// The global state and the block state are each hashed at every position before the too far boundary
+	if calls.Load() != 2*backend.tooFarStartsAtPosition {
+		Fail(t, "expected the configured hasher to be used for every hash, got", calls.Load(), "calls")
+	}
+
+	// Exec challenge global state hashes use the configured hasher too, so they're consistent with the step hashes
+	state := &ChallengeState{
+		Start:       big.NewInt(4),
+		End:         big.NewInt(5),
+		RawSegments: make([][32]byte, 2),
+		Segments:    []ChallengeSegment{{Position: 4}, {Position: 5}},
+	}
+	calls.Store(0)
+	args, err := backend.getExecChallengeArgs(state, 0)
+	Require(t, err)
+	if calls.Load() != 2 {
+		Fail(t, "expected the configured hasher to hash both exec challenge global states, got", calls.Load(), "calls")
+	}
+	for i, gs := range args.globalStates {
+		if args.globalStateHashes[i] != gs.Hash() {
+			Fail(t, "exec challenge global state hash", i, "doesn't match the global state")
+		}
+	}
+}
+
+func benchmarkHasher(b *testing.B, hasher validator.Keccak256Hasher) {
+	ctx := context.Background()
+	backend := newTestBlockChallengeBackend(b, NewFakeBatchMetadataSource(3, 4, 5), nil, testHasherConfig(hasher))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.GetHashAtStep(ctx, uint64(i)%backend.tooFarStartsAtPosition); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDefaultHasher(b *testing.B) {
+	benchmarkHasher(b, nil)
+}
+
+func BenchmarkNoopHasher(b *testing.B) {
+	benchmarkHasher(b, func(...[]byte) common.Hash { return common.Hash{} })
+}
+
+func TestDetectDuplicateBlockHashes(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(2, 3, 1, 4, 2)
+	backend := chain.newBackend(t, 1)
+	// The range runs past the too far boundary, whose positions all have the zero block hash
+	duplicates, err := backend.DetectDuplicateBlockHashes(ctx, 0, backend.tooFarStartsAtPosition+3)
+	Require(t, err)
+	if len(duplicates) != 0 {
+		Fail(t, "expected no duplicate block hashes, got", duplicates)
+	}
+	// A stale lookup returns the previous block for message counts 7 and 8, at positions 5 and 6
+	chain.results[7] = chain.results[6]
+	chain.results[8] = chain.results[6]
+	backend = chain.newBackend(t, 1)
+	duplicates, err = backend.DetectDuplicateBlockHashes(ctx, 0, backend.tooFarStartsAtPosition)
+	Require(t, err)
+	expected := []DuplicateBlockHash{
+		{Position: 4, NextPosition: 5, BlockHash: chain.results[6].BlockHash},
+		{Position: 5, NextPosition: 6, BlockHash: chain.results[6].BlockHash},
+	}
+	if fmt.Sprint(duplicates) != fmt.Sprint(expected) {
+		Fail(t, "expected duplicates", expected, "got", duplicates)
+	}
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := backend.DetectDuplicateBlockHashes(cancelledCtx, 0, backend.tooFarStartsAtPosition); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected a cancelled context to stop the scan, got", err)
+	}
+}
+
+func TestLazyBlockChallengeBackend(t *testing.T) {
+	ctx := context.Background()
+	var inits atomic.Int32
+	failNext := true
+	// The backend is created up front, as initialization may run on a goroutine the test didn't start
+	initialized := newTestBlockChallengeBackend(t, NewFakeBatchMetadataSource(3, 4, 5), nil, nil)
+	lazy := newLazyBlockChallengeBackend(func(ctx context.Context) (*BlockChallengeBackend, error) {
+		inits.Add(1)
+		if failNext {
+			failNext = false
+			return nil, errors.New("transient error")
+		}
+		return initialized, nil
+	})
+	if lazy.Initialized() || inits.Load() != 0 {
+		Fail(t, "expected no initialization before first use")
+	}
+	if _, err := lazy.GetHashAtStep(ctx, 0); err == nil {
+		Fail(t, "expected the initialization error to be returned")
+	}
+	if lazy.Initialized() {
+		Fail(t, "expected a failed initialization to leave the backend uninitialized")
+	}
+	// Concurrent first calls after the failure retry initialization once between them
+	var wg sync.WaitGroup
+	hashes := make([]common.Hash, 8)
+	errs := make([]error, len(hashes))
+	for i := range hashes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hashes[i], errs[i] = lazy.GetHashAtStep(ctx, uint64(i))
+		}()
+	}
+	wg.Wait()
+	if inits.Load() != 2 {
+		Fail(t, "expected initialization to be retried once, got", inits.Load(), "initializations")
+	}
+	backend, err := lazy.Backend(ctx)
+	Require(t, err)
+	if backend != initialized {
+		Fail(t, "expected the initialized backend to be reused")
+	}
+	for i, hash := range hashes {
+		Require(t, errs[i])
+		expected, err := backend.GetHashAtStep(ctx, uint64(i))
+		Require(t, err)
+		if hash != expected {
+			Fail(t, "hash at position", i, "doesn't match the underlying backend's")
+		}
+	}
+	Require(t, lazy.SetRange(ctx, 2, 6))
+	if backend.startPosition != 2 || backend.endPosition != 6 || inits.Load() != 2 {
+		Fail(t, "expected SetRange to narrow the initialized backend without initializing again")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+// TraceFormat is the format ExportTrace writes steps in.
+type TraceFormat string
+
+const (
+	// TraceFormatCSV writes a header row followed by a row per step.
+	TraceFormatCSV TraceFormat = "csv"
+	// TraceFormatJSON writes a JSON object per step, one per line.
+	TraceFormatJSON TraceFormat = "json"
+)
+
+var traceCSVHeader = []string{"position", "batch", "posInBatch", "blockHash", "sendRoot", "status", "hash"}
+
+type traceStep struct {
+	Position   uint64      `json:"position"`
+	Batch      uint64      `json:"batch"`
+	PosInBatch uint64      `json:"posInBatch"`
+	BlockHash  common.Hash `json:"blockHash"`
+	SendRoot   common.Hash `json:"sendRoot"`
+	Status     uint8       `json:"status"`
+	Hash       common.Hash `json:"hash"`
+}
+
+// ExportTrace replays every step of the challenge that isn't too far, writing each step's global state, status and
+// hash to w in the given format, e.g. to attach to a bug report. Each step is written as soon as it's replayed,
+// so exporting a long challenge doesn't buffer it in memory, and a cancelled export leaves the steps so far.
+func (b *BlockChallengeBackend) ExportTrace(ctx context.Context, w io.Writer, format TraceFormat) error {
+	var writeStep func(step traceStep) error
+	switch format {
+	case TraceFormatCSV:
+		csvWriter := csv.NewWriter(w)
+		writeStep = func(step traceStep) error {
+			record := []string{
+				strconv.FormatUint(step.Position, 10),
+				strconv.FormatUint(step.Batch, 10),
+				strconv.FormatUint(step.PosInBatch, 10),
+				step.BlockHash.Hex(),
+				step.SendRoot.Hex(),
+				strconv.FormatUint(uint64(step.Status), 10),
+				step.Hash.Hex(),
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return err
+			}
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+		// The header is flushed straight away, so a trace whose first step fails still has it
+		if err := csvWriter.Write(traceCSVHeader); err != nil {
+			return fmt.Errorf("error writing trace header: %w", err)
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return fmt.Errorf("error writing trace header: %w", err)
+		}
+	case TraceFormatJSON:
+		encoder := json.NewEncoder(w)
+		writeStep = func(step traceStep) error {
+			return encoder.Encode(step)
+		}
+	default:
+		return fmt.Errorf("unknown trace format %q", format)
+	}
+	return b.Replay(ctx, func(position uint64, gs validator.GoGlobalState, status uint8) error {
+		hash, err := b.hashBlockState(gs, status)
+		if err != nil {
+			return fmt.Errorf("failed to hash block state at position %v: %w", position, err)
+		}
+		step := traceStep{
+			Position:   position,
+			Batch:      gs.Batch,
+			PosInBatch: gs.PosInBatch,
+			BlockHash:  gs.BlockHash,
+			SendRoot:   gs.SendRoot,
+			Status:     status,
+			Hash:       hash,
+		}
+		if err := writeStep(step); err != nil {
+			return fmt.Errorf("error writing trace of step %v: %w", position, err)
+		}
+		return nil
+	})
+}
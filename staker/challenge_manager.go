@@ -126,6 +126,7 @@ func NewChallengeManager(
 	}
 
 	backend, err := NewBlockChallengeBackend(
+		ctx,
 		parsedLog,
 		challengeInfo.MaxInboxMessages,
 		val.streamer,
@@ -134,6 +135,7 @@ func NewChallengeManager(
 	if err != nil {
 		return nil, fmt.Errorf("error creating block challenge backend for challenge %v: %w", challengeIndex, err)
 	}
+	backend.EnableStepStatusMetrics(challengeIndex)
 	return &ChallengeManager{
 		challengeCore: &challengeCore{
 			con:                  con,
@@ -152,6 +154,82 @@ func NewChallengeManager(
 	}, nil
 }
 
+// CheckContractCode errors if there's no contract code at the given address, e.g. because it's misconfigured
+// as the zero address or an EOA, which would otherwise surface as a confusing error from the first contract call.
+// The challenge manager contract has no version getter to check against the challengegen bindings, so this is
+// the only check made of the contract before it's used.
+func CheckContractCode(ctx context.Context, client bind.ContractCaller, addr common.Address) error {
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return fmt.Errorf("error getting code at %v: %w", addr, err)
+	}
+	if len(code) == 0 {
+		return fmt.Errorf("no contract code at %v", addr)
+	}
+	return nil
+}
+
+// FetchChallengeGlobalStates reads the start and end global states of each of the given challenges from
+// their InitiatedChallenge events, using a single log query for all of them.
+// A challenge whose event can't be found or parsed has its error recorded in the returned error map
+// rather than aborting the whole batch.
+func FetchChallengeGlobalStates(
+	ctx context.Context,
+	l1client bind.ContractBackend,
+	challengeManagerAddr common.Address,
+	startL1Block uint64,
+	challengeIndexes []uint64,
+) (map[uint64][2]validator.GoGlobalState, map[uint64]error, error) {
+	states := make(map[uint64][2]validator.GoGlobalState)
+	errs := make(map[uint64]error)
+	if len(challengeIndexes) == 0 {
+		// An empty topic list would match the events of every challenge
+		return states, errs, nil
+	}
+	con, err := challengegen.NewChallengeManager(challengeManagerAddr, l1client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating bindgen ChallengeManager: %w", err)
+	}
+	indexTopics := make([]common.Hash, len(challengeIndexes))
+	for i, challengeIndex := range challengeIndexes {
+		indexTopics[i] = uint64ToIndex(challengeIndex)
+	}
+	logs, err := l1client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(startL1Block),
+		Addresses: []common.Address{challengeManagerAddr},
+		Topics:    [][]common.Hash{{initiatedChallengeID}, indexTopics},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error searching logs for InitiatedChallenge events from block %v: %w", startL1Block, err)
+	}
+	// Logs are in ascending order, so as in NewChallengeManager, the most recent log of each challenge is used.
+	// If it can't be parsed, the challenge's error is recorded even if an earlier log was parsed.
+	for _, evmLog := range logs {
+		if len(evmLog.Topics) < 2 {
+			continue
+		}
+		challengeIndex := binary.BigEndian.Uint64(evmLog.Topics[1][(32 - 8):])
+		parsedLog, err := con.ParseInitiatedChallenge(evmLog)
+		if err != nil {
+			delete(states, challengeIndex)
+			errs[challengeIndex] = fmt.Errorf("error parsing InitiatedChallenge event for challenge %v: %w", challengeIndex, err)
+			continue
+		}
+		states[challengeIndex] = [2]validator.GoGlobalState{
+			validator.GoGlobalStateFromSolidity(parsedLog.StartState),
+			validator.GoGlobalStateFromSolidity(parsedLog.EndState),
+		}
+		delete(errs, challengeIndex)
+	}
+	for _, challengeIndex := range challengeIndexes {
+		_, found := states[challengeIndex]
+		if _, failed := errs[challengeIndex]; !found && !failed {
+			errs[challengeIndex] = fmt.Errorf("didn't find InitiatedChallenge event for challenge %v starting at block %v", challengeIndex, startL1Block)
+		}
+	}
+	return states, errs, nil
+}
+
 // NewExecutionChallengeManager is for testing only - skips block challenges
 func NewExecutionChallengeManager(
 	l1client bind.ContractBackend,
@@ -225,6 +303,22 @@ func (m *ChallengeManager) ChallengeIndex() uint64 {
 	return m.challengeIndex
 }
 
+// Close releases what's held for the challenge outside the manager, i.e. the block challenge backend's step
+// status metrics. The manager shouldn't be used after it's closed.
+func (m *ChallengeManager) Close() {
+	if m.blockChallengeBackend != nil {
+		m.blockChallengeBackend.DisableStepStatusMetrics()
+	}
+}
+
+// Contract returns the challenge manager binding the challenge is driven through, so callers can read
+// contract state the manager doesn't expose without building another binding. Block challenges don't have
+// a contract of their own and BlockChallengeBackend holds no binding, so this is the one to use for them too.
+// Calling mutating methods on it bypasses the manager's bookkeeping and can leave it acting on stale state.
+func (m *ChallengeManager) Contract() *challengegen.ChallengeManager {
+	return m.con
+}
+
 func uint64ToIndex(val uint64) common.Hash {
 	var challengeIndex common.Hash
 	binary.BigEndian.PutUint64(challengeIndex[(32-8):], val)
@@ -296,20 +390,11 @@ func (m *ChallengeManager) bisect(ctx context.Context, backend ChallengeBackend,
 		bisectionDegree = newChallengeLength
 	}
 	newSegments := make([][32]byte, bisectionDegree+1)
-	position := startSegmentPosition
-	normalSegmentLength := newChallengeLength / bisectionDegree
-	for i := range newSegments {
-		if i == len(newSegments)-1 {
-			if position > endSegmentPosition {
-				return nil, errors.New("computed last segment position past end when bisecting")
-			}
-			position = endSegmentPosition
-		}
+	for i, position := range segmentPositions(startSegmentPosition, endSegmentPosition, bisectionDegree) {
 		newSegments[i], err = backend.GetHashAtStep(ctx, position)
 		if err != nil {
 			return nil, fmt.Errorf("error getting challenge %v hash at step %v: %w", m.challengeIndex, position, err)
 		}
-		position += normalSegmentLength
 	}
 	return m.con.BisectExecution(
 		m.auth,
@@ -497,10 +582,11 @@ func (m *ChallengeManager) createExecutionBackend(ctx context.Context, step uint
 	if err != nil {
 		return err
 	}
-	expectedState, expectedStatus, err := m.blockChallengeBackend.GetInfoAtStep(step + 1)
+	expectedInfo, err := m.blockChallengeBackend.GetInfoAtStep(step + 1)
 	if err != nil {
 		return fmt.Errorf("error getting info from block challenge backend: %w", err)
 	}
+	expectedState, expectedStatus := expectedInfo.GlobalState, expectedInfo.Status
 	machineStepCount, computedState, computedStatus, err := backend.GetFinalState(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting execution challenge final state: %w", err)
@@ -0,0 +1,146 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+	"github.com/offchainlabs/nitro/validator"
+)
+
+// fakeChallengeManagerBackend serves the logs and contract code of fake contracts.
+// Every other method panics, via the nil embedded backend.
+type fakeChallengeManagerBackend struct {
+	bind.ContractBackend
+	logs    []types.Log
+	code    map[common.Address][]byte
+	codeErr error
+}
+
+func (b *fakeChallengeManagerBackend) FilterLogs(_ context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	for _, evmLog := range b.logs {
+		if query.FromBlock != nil && evmLog.BlockNumber < query.FromBlock.Uint64() {
+			continue
+		}
+		if len(query.Addresses) > 0 && !slices.Contains(query.Addresses, evmLog.Address) {
+			continue
+		}
+		matches := true
+		for i, topics := range query.Topics {
+			if len(topics) > 0 && (i >= len(evmLog.Topics) || !slices.Contains(topics, evmLog.Topics[i])) {
+				matches = false
+			}
+		}
+		if matches {
+			logs = append(logs, evmLog)
+		}
+	}
+	return logs, nil
+}
+
+func (b *fakeChallengeManagerBackend) CodeAt(_ context.Context, contract common.Address, _ *big.Int) ([]byte, error) {
+	if b.codeErr != nil {
+		return nil, b.codeErr
+	}
+	return b.code[contract], nil
+}
+
+// initiatedChallengeLog creates an InitiatedChallenge event log of a challenge from startGs to endGs.
+func initiatedChallengeLog(t *testing.T, challengeManagerAddr common.Address, blockNumber uint64, challengeIndex uint64, startGs validator.GoGlobalState, endGs validator.GoGlobalState) types.Log {
+	t.Helper()
+	parsedABI, err := challengegen.ChallengeManagerMetaData.GetAbi()
+	Require(t, err)
+	data, err := parsedABI.Events["InitiatedChallenge"].Inputs.NonIndexed().Pack(startGs.AsSolidityStruct(), endGs.AsSolidityStruct())
+	Require(t, err)
+	return types.Log{
+		Address:     challengeManagerAddr,
+		Topics:      []common.Hash{initiatedChallengeID, uint64ToIndex(challengeIndex)},
+		Data:        data,
+		BlockNumber: blockNumber,
+	}
+}
+
+func TestFetchChallengeGlobalStates(t *testing.T) {
+	ctx := context.Background()
+	challengeManagerAddr := common.HexToAddress("0x1234")
+	gs := func(batch uint64) validator.GoGlobalState {
+		return validator.GoGlobalState{BlockHash: testBlockHash(arbutil.MessageIndex(batch)), Batch: batch}
+	}
+	badLog := initiatedChallengeLog(t, challengeManagerAddr, 12, 3, gs(5), gs(6))
+	badLog.Data = []byte{1, 2, 3}
+	backend := &fakeChallengeManagerBackend{logs: []types.Log{
+		initiatedChallengeLog(t, challengeManagerAddr, 10, 1, gs(1), gs(2)),
+		initiatedChallengeLog(t, challengeManagerAddr, 10, 2, gs(2), gs(3)),
+		initiatedChallengeLog(t, challengeManagerAddr, 11, 3, gs(3), gs(4)),
+		// A later log of challenge 2 replaces the earlier one, and a later bad log of challenge 3 replaces its state
+		initiatedChallengeLog(t, challengeManagerAddr, 12, 2, gs(7), gs(8)),
+		badLog,
+		// Logs of other challenge managers and from before the start block are ignored
+		initiatedChallengeLog(t, common.HexToAddress("0x5678"), 12, 1, gs(9), gs(10)),
+		initiatedChallengeLog(t, challengeManagerAddr, 5, 4, gs(1), gs(2)),
+	}}
+	states, errs, err := FetchChallengeGlobalStates(ctx, backend, challengeManagerAddr, 10, []uint64{1, 2, 3, 4})
+	Require(t, err)
+	expected := map[uint64][2]validator.GoGlobalState{
+		1: {gs(1), gs(2)},
+		2: {gs(7), gs(8)},
+	}
+	if len(states) != len(expected) {
+		Fail(t, "expected states of", len(expected), "challenges, got", states)
+	}
+	for challengeIndex, expectedStates := range expected {
+		if states[challengeIndex] != expectedStates {
+			Fail(t, "challenge", challengeIndex, "has states", states[challengeIndex], "expected", expectedStates)
+		}
+		if errs[challengeIndex] != nil {
+			Fail(t, "unexpected error for challenge", challengeIndex, errs[challengeIndex])
+		}
+	}
+	if errs[3] == nil {
+		Fail(t, "expected an error for challenge 3, whose most recent log is bad")
+	}
+	if errs[4] == nil {
+		Fail(t, "expected an error for challenge 4, which has no log after the start block")
+	}
+
+	states, errs, err = FetchChallengeGlobalStates(ctx, backend, challengeManagerAddr, 10, nil)
+	Require(t, err)
+	if len(states) != 0 || len(errs) != 0 {
+		Fail(t, "expected no states or errors for no challenges, got", states, errs)
+	}
+}
+
+func TestCheckContractCode(t *testing.T) {
+	ctx := context.Background()
+	contractAddr := common.HexToAddress("0x1234")
+	backend := &fakeChallengeManagerBackend{code: map[common.Address][]byte{contractAddr: {0x60, 0x80}}}
+	Require(t, CheckContractCode(ctx, backend, contractAddr))
+	// The zero address and an EOA have no code, even though another address does
+	for _, addr := range []common.Address{{}, common.HexToAddress("0x5678")} {
+		err := CheckContractCode(ctx, backend, addr)
+		if err == nil || !strings.Contains(err.Error(), "no contract code") {
+			Fail(t, "expected no code at", addr, "to be rejected, got", err)
+		}
+	}
+	backend.code[contractAddr] = []byte{}
+	if err := CheckContractCode(ctx, backend, contractAddr); err == nil {
+		Fail(t, "expected empty code to be rejected")
+	}
+	backend.codeErr = errors.New("connection refused")
+	if err := CheckContractCode(ctx, backend, contractAddr); !errors.Is(err, backend.codeErr) {
+		Fail(t, "expected the code reader's error, got", err)
+	}
+}
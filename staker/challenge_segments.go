@@ -0,0 +1,38 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package staker
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// segmentPositions returns the positions of the degree+1 boundaries of a bisection from start to end,
+// spaced as the challenge contract expects: every segment has the same length, rounded down,
+// except for the last segment which also covers the remainder.
+// The degree must be non-zero.
+func segmentPositions(start uint64, end uint64, degree uint64) []uint64 {
+	positions := make([]uint64, degree+1)
+	segmentLength := (end - start) / degree
+	for i := range positions {
+		positions[i] = start + uint64(i)*segmentLength
+	}
+	positions[degree] = end
+	return positions
+}
+
+// hashChallengeState computes the challenge state hash the challenge contract stores for a set of segments,
+// keccak256(abi.encodePacked(segmentsStart, segmentsLength, segments)).
+func hashChallengeState(segmentsStart *big.Int, segmentsLength *big.Int, segments []common.Hash) common.Hash {
+	data := make([]byte, 0, 64+32*len(segments))
+	data = append(data, arbmath.U256Bytes(segmentsStart)...)
+	data = append(data, arbmath.U256Bytes(segmentsLength)...)
+	for _, segment := range segments {
+		data = append(data, segment.Bytes()...)
+	}
+	return crypto.Keccak256Hash(data)
+}
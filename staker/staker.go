@@ -95,6 +95,7 @@ type L1ValidatorConfig struct {
 	ParentChainWallet         genericconf.WalletConfig    `koanf:"parent-chain-wallet"`
 	LogQueryBatchSize         uint64                      `koanf:"log-query-batch-size" reload:"hot"`
 	EnableFastConfirmation    bool                        `koanf:"enable-fast-confirmation"`
+	CheckChallengeManagerCode bool                        `koanf:"check-challenge-manager-code"`
 
 	strategy    StakerStrategy
 	gasRefunder common.Address
@@ -165,6 +166,7 @@ var DefaultL1ValidatorConfig = L1ValidatorConfig{
 	ParentChainWallet:         DefaultValidatorL1WalletConfig,
 	LogQueryBatchSize:         0,
 	EnableFastConfirmation:    false,
+	CheckChallengeManagerCode: false,
 }
 
 var TestL1ValidatorConfig = L1ValidatorConfig{
@@ -187,6 +189,7 @@ var TestL1ValidatorConfig = L1ValidatorConfig{
 	ParentChainWallet:         DefaultValidatorL1WalletConfig,
 	LogQueryBatchSize:         0,
 	EnableFastConfirmation:    false,
+	CheckChallengeManagerCode: false,
 }
 
 var DefaultValidatorL1WalletConfig = genericconf.WalletConfig{
@@ -217,6 +220,7 @@ func L1ValidatorConfigAddOptions(prefix string, f *flag.FlagSet) {
 	DangerousConfigAddOptions(prefix+".dangerous", f)
 	genericconf.WalletConfigAddOptions(prefix+".parent-chain-wallet", f, DefaultL1ValidatorConfig.ParentChainWallet.Pathname)
 	f.Bool(prefix+".enable-fast-confirmation", DefaultL1ValidatorConfig.EnableFastConfirmation, "enable fast confirmation")
+	f.Bool(prefix+".check-challenge-manager-code", DefaultL1ValidatorConfig.CheckChallengeManagerCode, "check there's contract code at the challenge manager address before acting in a challenge")
 }
 
 type DangerousConfig struct {
@@ -963,6 +967,9 @@ func (s *Staker) Act(ctx context.Context) (*types.Transaction, error) {
 
 func (s *Staker) handleConflict(ctx context.Context, info *StakerInfo) error {
 	if info.CurrentChallenge == nil {
+		if s.activeChallenge != nil {
+			s.activeChallenge.Close()
+		}
 		s.activeChallenge = nil
 		return nil
 	}
@@ -975,6 +982,12 @@ func (s *Staker) handleConflict(ctx context.Context, info *StakerInfo) error {
 			return fmt.Errorf("error getting latest confirmed creation block: %w", err)
 		}
 
+		if s.config().CheckChallengeManagerCode {
+			if err := CheckContractCode(ctx, s.builder, s.wallet.ChallengeManagerAddress()); err != nil {
+				return fmt.Errorf("error checking challenge manager: %w", err)
+			}
+		}
+
 		newChallengeManager, err := NewChallengeManager(
 			ctx,
 			s.builder,
@@ -990,6 +1003,9 @@ func (s *Staker) handleConflict(ctx context.Context, info *StakerInfo) error {
 			return fmt.Errorf("error creating challenge manager: %w", err)
 		}
 
+		if s.activeChallenge != nil {
+			s.activeChallenge.Close()
+		}
 		s.activeChallenge = newChallengeManager
 	}
 
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -11,11 +12,15 @@ import (
 	"github.com/offchainlabs/nitro/solgen/go/rollupgen"
 )
 
+// GoGlobalState mirrors the Solidity GlobalState struct, which carries both the block hash and the send root
+// in Bytes32Vals, and the batch and position in U64Vals, all of which are committed to by Hash.
+// Both arrays are of a fixed length in the contracts and the one step prover, so a field such as a block
+// timestamp can't be added here, or to the hash, without a matching change to the protocol.
 type GoGlobalState struct {
-	BlockHash  common.Hash
-	SendRoot   common.Hash
-	Batch      uint64
-	PosInBatch uint64
+	BlockHash  common.Hash // Bytes32Vals[0]
+	SendRoot   common.Hash // Bytes32Vals[1]
+	Batch      uint64      // U64Vals[0]
+	PosInBatch uint64      // U64Vals[1]
 }
 
 type MachineStatus uint8
@@ -39,12 +44,110 @@ func u64ToBe(x uint64) []byte {
 }
 
 func (s GoGlobalState) Hash() common.Hash {
+	return HashGlobalStateComponents(s.BlockHash, s.SendRoot, s.Batch, s.PosInBatch)
+}
+
+// Keccak256Hasher computes the keccak256 hash of the concatenation of its arguments, as crypto.Keccak256Hash does.
+// Alternative implementations, e.g. hardware accelerated ones, must produce identical hashes.
+type Keccak256Hasher func(data ...[]byte) common.Hash
+
+// HashWith is Hash, but computing the hash with the given hasher, or crypto.Keccak256Hash if it's nil.
+func (s GoGlobalState) HashWith(hasher Keccak256Hasher) common.Hash {
+	return hashGlobalStateComponents(hasher, 8, s.BlockHash, s.SendRoot, s.Batch, s.PosInBatch)
+}
+
+// GlobalStateEncoding is the protocol version of the encoding of global states' integers when hashing them.
+type GlobalStateEncoding uint8
+
+const (
+	// GlobalStateEncodingU64 encodes the batch and position in batch as 8 byte big endian integers, as the
+	// current contracts do. It's the zero value, and the encoding used by Hash.
+	GlobalStateEncodingU64 GlobalStateEncoding = iota
+	// GlobalStateEncodingU128 encodes the batch and position in batch as 16 byte big endian integers,
+	// for compatibility with a protocol variant using wider integers.
+	GlobalStateEncodingU128
+)
+
+func (e GlobalStateEncoding) intWidth() (int, error) {
+	switch e {
+	case GlobalStateEncodingU64:
+		return 8, nil
+	case GlobalStateEncodingU128:
+		return 16, nil
+	default:
+		return 0, fmt.Errorf("unknown global state encoding %v", uint8(e))
+	}
+}
+
+// HashWithEncoding is Hash, but with the batch and position in batch encoded as the given encoding specifies.
+func (s GoGlobalState) HashWithEncoding(encoding GlobalStateEncoding) (common.Hash, error) {
+	width, err := encoding.intWidth()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return hashGlobalStateComponents(crypto.Keccak256Hash, width, s.BlockHash, s.SendRoot, s.Batch, s.PosInBatch), nil
+}
+
+// HashGlobalStateComponents is GoGlobalState.Hash over the state's fields, for callers that don't have a GoGlobalState.
+// The send root is committed to by the hash too, so unlike the block hash, batch and position it can't be omitted.
+func HashGlobalStateComponents(blockHash common.Hash, sendRoot common.Hash, batch uint64, posInBatch uint64) common.Hash {
+	return hashGlobalStateComponents(crypto.Keccak256Hash, 8, blockHash, sendRoot, batch, posInBatch)
+}
+
+// hashGlobalStateComponents hashes a global state with the given hasher, or crypto.Keccak256Hash if it's nil,
+// encoding the batch and position in batch as big endian integers of intWidth bytes, which must be at least 8.
+func hashGlobalStateComponents(hasher Keccak256Hasher, intWidth int, blockHash common.Hash, sendRoot common.Hash, batch uint64, posInBatch uint64) common.Hash {
+	if hasher == nil {
+		hasher = crypto.Keccak256Hash
+	}
 	data := []byte("Global state:")
-	data = append(data, s.BlockHash.Bytes()...)
-	data = append(data, s.SendRoot.Bytes()...)
-	data = append(data, u64ToBe(s.Batch)...)
-	data = append(data, u64ToBe(s.PosInBatch)...)
-	return crypto.Keccak256Hash(data)
+	data = append(data, blockHash.Bytes()...)
+	data = append(data, sendRoot.Bytes()...)
+	data = append(data, make([]byte, intWidth-8)...)
+	data = append(data, u64ToBe(batch)...)
+	data = append(data, make([]byte, intWidth-8)...)
+	data = append(data, u64ToBe(posInBatch)...)
+	return hasher(data)
+}
+
+// Validate performs cheap sanity checks on the global state, catching states that were likely decoded incorrectly.
+// Only the all-zero state may have a zero block hash, as any position past the start of the chain has a block.
+func (s GoGlobalState) Validate() error {
+	if s.BlockHash == (common.Hash{}) && (s.Batch != 0 || s.PosInBatch != 0) {
+		return fmt.Errorf("global state at batch %v position %v has a zero block hash", s.Batch, s.PosInBatch)
+	}
+	return nil
+}
+
+// HashGlobalStates returns the hash of each of the given global states, in order.
+func HashGlobalStates(states []GoGlobalState) [][32]byte {
+	hashes := make([][32]byte, len(states))
+	for i, state := range states {
+		hashes[i] = state.Hash()
+	}
+	return hashes
+}
+
+// DiffGlobalStates describes each field that differs between two global states, e.g. "Batch: 5 != 7",
+// or returns "identical" if they're equal.
+func DiffGlobalStates(a, b GoGlobalState) string {
+	var diffs []string
+	if a.BlockHash != b.BlockHash {
+		diffs = append(diffs, fmt.Sprintf("BlockHash: %v != %v", a.BlockHash, b.BlockHash))
+	}
+	if a.SendRoot != b.SendRoot {
+		diffs = append(diffs, fmt.Sprintf("SendRoot: %v != %v", a.SendRoot, b.SendRoot))
+	}
+	if a.Batch != b.Batch {
+		diffs = append(diffs, fmt.Sprintf("Batch: %v != %v", a.Batch, b.Batch))
+	}
+	if a.PosInBatch != b.PosInBatch {
+		diffs = append(diffs, fmt.Sprintf("PosInBatch: %v != %v", a.PosInBatch, b.PosInBatch))
+	}
+	if len(diffs) == 0 {
+		return "identical"
+	}
+	return strings.Join(diffs, ", ")
 }
 
 func (s GoGlobalState) AsSolidityStruct() challengegen.GlobalState {
@@ -70,6 +173,17 @@ func GoGlobalStateFromSolidity(gs challengegen.GlobalState) GoGlobalState {
 	}
 }
 
+// GoGlobalStateFromSolidityChecked is GoGlobalStateFromSolidity for untrusted input.
+// The generated bindings use fixed size arrays, so decoding itself can't fail, but the decoded
+// state is validated to catch values that can't have come from a real global state.
+func GoGlobalStateFromSolidityChecked(gs challengegen.GlobalState) (GoGlobalState, error) {
+	state := GoGlobalStateFromSolidity(gs)
+	if err := state.Validate(); err != nil {
+		return GoGlobalState{}, fmt.Errorf("invalid solidity global state: %w", err)
+	}
+	return state, nil
+}
+
 func (s *ExecutionState) AsSolidityStruct() rollupgen.ExecutionState {
 	return rollupgen.ExecutionState{
 		GlobalState:   rollupgen.GlobalState(s.GlobalState.AsSolidityStruct()),
@@ -0,0 +1,193 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/OffchainLabs/nitro/blob/master/LICENSE
+
+package validator
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/solgen/go/challengegen"
+)
+
+func TestHashGlobalStates(t *testing.T) {
+	states := []GoGlobalState{
+		{BlockHash: common.HexToHash("0x01"), SendRoot: common.HexToHash("0x02"), Batch: 1, PosInBatch: 0},
+		{BlockHash: common.HexToHash("0x03"), SendRoot: common.HexToHash("0x04"), Batch: 1, PosInBatch: 5},
+		{BlockHash: common.HexToHash("0x05"), SendRoot: common.HexToHash("0x06"), Batch: 2, PosInBatch: 0},
+	}
+	hashes := HashGlobalStates(states)
+	if len(hashes) != len(states) {
+		t.Fatalf("got %v hashes for %v states", len(hashes), len(states))
+	}
+	for i, state := range states {
+		if hashes[i] != state.Hash() {
+			t.Errorf("hash %v is %v but expected %v", i, common.Hash(hashes[i]), state.Hash())
+		}
+	}
+}
+
+func TestGoGlobalStateFromSolidityChecked(t *testing.T) {
+	valid := GoGlobalState{BlockHash: common.HexToHash("0x01"), SendRoot: common.HexToHash("0x02"), Batch: 3, PosInBatch: 4}
+	decoded, err := GoGlobalStateFromSolidityChecked(valid.AsSolidityStruct())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != valid {
+		t.Errorf("decoded %v but expected %v", decoded, valid)
+	}
+	malformed := challengegen.GlobalState{U64Vals: [2]uint64{3, 4}}
+	if _, err := GoGlobalStateFromSolidityChecked(malformed); err == nil {
+		t.Error("expected global state with a zero block hash to be rejected")
+	}
+}
+
+func TestValidateGlobalState(t *testing.T) {
+	blockHash := common.HexToHash("0x01")
+	sendRoot := common.HexToHash("0x02")
+	tests := []struct {
+		name  string
+		state GoGlobalState
+		valid bool
+	}{
+		{"zero", GoGlobalState{}, true},
+		{"zero with send root", GoGlobalState{SendRoot: sendRoot}, true},
+		{"block hash only", GoGlobalState{BlockHash: blockHash}, true},
+		{"complete", GoGlobalState{BlockHash: blockHash, SendRoot: sendRoot, Batch: 3, PosInBatch: 4}, true},
+		{"zero block hash with batch", GoGlobalState{Batch: 3}, false},
+		{"zero block hash with position in batch", GoGlobalState{PosInBatch: 4}, false},
+		{"zero block hash with batch and position", GoGlobalState{SendRoot: sendRoot, Batch: 3, PosInBatch: 4}, false},
+	}
+	for _, test := range tests {
+		err := test.state.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%v: expected global state %v to be valid, got %v", test.name, test.state, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%v: expected global state %v to be rejected", test.name, test.state)
+		}
+	}
+}
+
+func randomGlobalState(t *testing.T, r *rand.Rand) GoGlobalState {
+	t.Helper()
+	var gs GoGlobalState
+	if _, err := r.Read(gs.BlockHash[:]); err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	if _, err := r.Read(gs.SendRoot[:]); err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	gs.Batch = r.Uint64()
+	gs.PosInBatch = r.Uint64()
+	return gs
+}
+
+func TestGoGlobalStateSolidityRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		gs := randomGlobalState(t, r)
+		if decoded := GoGlobalStateFromSolidity(gs.AsSolidityStruct()); decoded != gs {
+			t.Errorf("global state %v round tripped through solidity to %v", gs, decoded)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		gs := randomGlobalState(t, r)
+		sol := challengegen.GlobalState{
+			Bytes32Vals: [2][32]byte{gs.BlockHash, gs.SendRoot},
+			U64Vals:     [2]uint64{gs.Batch, gs.PosInBatch},
+		}
+		if encoded := GoGlobalStateFromSolidity(sol).AsSolidityStruct(); encoded != sol {
+			t.Errorf("solidity global state %v round tripped to %v", sol, encoded)
+		}
+		// Catches the fields being reordered if the bindings are regenerated with a different layout
+		if decoded := GoGlobalStateFromSolidity(sol); decoded != gs {
+			t.Errorf("solidity global state %v decoded to %v but expected %v", sol, decoded, gs)
+		}
+	}
+}
+
+func TestDiffGlobalStates(t *testing.T) {
+	a := GoGlobalState{BlockHash: common.HexToHash("0x01"), SendRoot: common.HexToHash("0x02"), Batch: 5, PosInBatch: 3}
+	if diff := DiffGlobalStates(a, a); diff != "identical" {
+		t.Errorf("expected equal states to be identical, got %q", diff)
+	}
+	b := a
+	b.Batch = 7
+	if diff := DiffGlobalStates(a, b); diff != "Batch: 5 != 7" {
+		t.Errorf("unexpected single field diff %q", diff)
+	}
+	b.BlockHash = common.HexToHash("0x03")
+	b.PosInBatch = 0
+	expected := fmt.Sprintf("BlockHash: %v != %v, Batch: 5 != 7, PosInBatch: 3 != 0", a.BlockHash, b.BlockHash)
+	if diff := DiffGlobalStates(a, b); diff != expected {
+		t.Errorf("unexpected multi field diff %q, expected %q", diff, expected)
+	}
+}
+
+func TestHashGlobalStateComponents(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		gs := randomGlobalState(t, r)
+		legacy := crypto.Keccak256Hash([]byte("Global state:"), gs.BlockHash.Bytes(), gs.SendRoot.Bytes(), u64ToBe(gs.Batch), u64ToBe(gs.PosInBatch))
+		if hash := HashGlobalStateComponents(gs.BlockHash, gs.SendRoot, gs.Batch, gs.PosInBatch); hash != legacy {
+			t.Errorf("components of %v hashed to %v but expected %v", gs, hash, legacy)
+		}
+		if gs.Hash() != legacy {
+			t.Errorf("%v hashed to %v but expected %v", gs, gs.Hash(), legacy)
+		}
+	}
+}
+
+func TestHashWithEncoding(t *testing.T) {
+	gs := GoGlobalState{BlockHash: common.HexToHash("0x01"), SendRoot: common.HexToHash("0x02"), Batch: 3, PosInBatch: 4}
+	u64, err := gs.HashWithEncoding(GlobalStateEncodingU64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u64 != gs.Hash() {
+		t.Errorf("default encoding hash %v doesn't match Hash %v", u64, gs.Hash())
+	}
+	u128, err := gs.HashWithEncoding(GlobalStateEncodingU128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wide := make([]byte, 16)
+	wide[15] = 3
+	widePos := make([]byte, 16)
+	widePos[15] = 4
+	expected := crypto.Keccak256Hash([]byte("Global state:"), gs.BlockHash.Bytes(), gs.SendRoot.Bytes(), wide, widePos)
+	if u128 != expected {
+		t.Errorf("wide encoding hash %v doesn't match expected %v", u128, expected)
+	}
+	if u128 == u64 {
+		t.Error("wide and default encodings hashed the same")
+	}
+	if _, err := gs.HashWithEncoding(GlobalStateEncoding(100)); err == nil {
+		t.Error("expected an unknown encoding to be rejected")
+	}
+}
+
+func TestHashWith(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		gs := randomGlobalState(t, r)
+		if gs.HashWith(nil) != gs.Hash() || gs.HashWith(crypto.Keccak256Hash) != gs.Hash() {
+			t.Errorf("global state %v hashed with the default hasher doesn't match Hash", gs)
+		}
+	}
+	var hashed []byte
+	gs := randomGlobalState(t, r)
+	gs.HashWith(func(data ...[]byte) common.Hash {
+		for _, d := range data {
+			hashed = append(hashed, d...)
+		}
+		return common.Hash{}
+	})
+	if crypto.Keccak256Hash(hashed) != gs.Hash() {
+		t.Error("the hasher wasn't passed the same preimage Hash uses")
+	}
+}